@@ -0,0 +1,515 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	htmltemplate "html/template"
+)
+
+// This file contains the custom printers that NewPrinter registers.
+
+// versionPrintFuncs holds functions that return custom printers for types that
+// are only present in some versions of Go. Files with build constraints add
+// to it.
+var versionPrintFuncs []func(*Printer) interface{}
+
+func (p *Printer) printTime(t time.Time) (string, error) {
+	if t.Location() == time.Local && p.localIn != nil {
+		t = t.In(p.localIn)
+	}
+	loc, err := p.printLocation(t.Location())
+	if err != nil {
+		return "", err
+	}
+	ident := p.PackageIdentifier("time")
+	return fmt.Sprintf("%s.Date(%d, %[1]s.%[3]s, %d, %d, %d, %d, %d, %s)",
+			ident,
+			t.Year(), t.Month(), t.Day(),
+			t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc),
+		nil
+}
+
+// durationUnits are the constants of the time package used to print durations.
+var durationUnits = []struct {
+	d    time.Duration
+	name string
+}{
+	{time.Hour, "Hour"},
+	{time.Minute, "Minute"},
+	{time.Second, "Second"},
+	{time.Millisecond, "Millisecond"},
+	{time.Microsecond, "Microsecond"},
+	{time.Nanosecond, "Nanosecond"},
+}
+
+// printDuration prints a duration as a constant expression using the unit
+// constants of the time package. A duration of less than an hour that is a
+// small multiple of a unit is printed as that multiple, like 90*time.Second.
+// Otherwise the duration is broken into multiples of successively smaller
+// units, like 1*time.Hour + 30*time.Minute.
+func (p *Printer) printDuration(d time.Duration) string {
+	if d == 0 || d == math.MinInt64 {
+		// 0 alone would be an untyped constant, and the magnitude of
+		// MinInt64 overflows a Duration.
+		return fmt.Sprintf("%s(%d)", p.qualifiedName("time", "Duration"), int64(d))
+	}
+	mag := d
+	if d < 0 {
+		mag = -d
+	}
+	type term struct {
+		n    int64
+		unit string
+	}
+	var terms []term
+	r := mag
+	var smallest time.Duration
+	for _, u := range durationUnits {
+		if n := r / u.d; n > 0 {
+			terms = append(terms, term{int64(n), u.name})
+			r -= n * u.d
+			smallest = u.d
+		}
+	}
+	if len(terms) > 1 && mag < time.Hour && mag/smallest < 10000 {
+		terms = terms[len(terms)-1:]
+		terms[0].n = int64(mag / smallest)
+	}
+	if len(terms) == 1 {
+		t := terms[0]
+		s := p.qualifiedName("time", t.unit)
+		if t.n != 1 {
+			s = fmt.Sprintf("%d * %s", t.n, s)
+		}
+		if d < 0 {
+			s = "-" + s
+		}
+		return s
+	}
+	var ss []string
+	for _, t := range terms {
+		ss = append(ss, fmt.Sprintf("%d*%s", t.n, p.qualifiedName("time", t.unit)))
+	}
+	s := strings.Join(ss, " + ")
+	if d < 0 {
+		s = "-(" + s + ")"
+	}
+	return s
+}
+
+// LocalTimesIn tells the Printer to convert times whose location is time.Local
+// to loc before printing them, and to print time.Local itself as loc. Without
+// it, such times are printed with time.Local, so their meaning depends on the
+// machine that runs the generated code.
+// It returns its receiver to support chaining.
+func (p *Printer) LocalTimesIn(loc *time.Location) *Printer {
+	p.localIn = loc
+	return p
+}
+
+// printLocation prints a time.Location. UTC and Local are printed as the
+// variables of the time package that hold them. A location with a single,
+// fixed offset is printed as a call to time.FixedZone. Any other location is
+// printed as a call to time.LoadLocation, provided the location that it loads
+// matches loc.
+func (p *Printer) printLocation(loc *time.Location) (string, error) {
+	if loc == nil {
+		return "(*" + p.qualifiedName("time", "Location") + ")(nil)", nil
+	}
+	if loc == time.Local && p.localIn != nil {
+		loc = p.localIn
+	}
+	switch loc {
+	case time.UTC:
+		return p.qualifiedName("time", "UTC"), nil
+	case time.Local:
+		return p.qualifiedName("time", "Local"), nil
+	}
+	name := loc.String()
+	form := p.locationForm(loc)
+	switch {
+	case form.fixed:
+		return fmt.Sprintf("%s(%q, %d)", p.qualifiedName("time", "FixedZone"), name, form.offset), nil
+	case !form.loadable:
+		return "", fmt.Errorf("don't know how to represent location %q in source", loc)
+	}
+	return fmt.Sprintf("func() *%s { loc, err := %s(%q); if err != nil { panic(err) }; return loc }()",
+		p.qualifiedName("time", "Location"), p.qualifiedName("time", "LoadLocation"), name), nil
+}
+
+// A locForm says how a time.Location other than UTC and Local can be
+// printed.
+type locForm struct {
+	fixed    bool // by time.FixedZone
+	offset   int  // the offset of a fixed zone
+	loadable bool // by time.LoadLocation
+}
+
+// locationForm returns the form of loc. Finding it involves many zone
+// lookups, and maybe reading the time zone database, so the form of each
+// location is computed once and remembered.
+func (p *Printer) locationForm(loc *time.Location) locForm {
+	p.mu.Lock()
+	form, ok := p.locForms[loc]
+	p.mu.Unlock()
+	if ok {
+		return form
+	}
+	if offset, ok := fixedOffset(loc); ok {
+		form = locForm{fixed: true, offset: offset}
+	} else if loaded, err := time.LoadLocation(loc.String()); err == nil && sameZones(loc, loaded) {
+		form = locForm{loadable: true}
+	}
+	p.mu.Lock()
+	p.locForms[loc] = form
+	p.mu.Unlock()
+	return form
+}
+
+// zoneSamples are the times at which two locations are compared. They include
+// a winter and a summer time in each year from 1970 through 2037.
+var zoneSamples []time.Time
+
+func init() {
+	for y := 1970; y <= 2037; y++ {
+		zoneSamples = append(zoneSamples,
+			time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(y, time.July, 1, 0, 0, 0, 0, time.UTC))
+	}
+}
+
+// fixedOffset reports whether loc behaves like a location created by
+// time.FixedZone, whose zone has the same name as the location and never
+// changes. If so, it also returns the zone's offset.
+func fixedOffset(loc *time.Location) (int, bool) {
+	name, offset := zoneSamples[0].In(loc).Zone()
+	if name != loc.String() {
+		return 0, false
+	}
+	for _, t := range zoneSamples[1:] {
+		if n, o := t.In(loc).Zone(); n != name || o != offset {
+			return 0, false
+		}
+	}
+	return offset, true
+}
+
+// sameZones reports whether loc1 and loc2 have the same zones at the sample times.
+func sameZones(loc1, loc2 *time.Location) bool {
+	for _, t := range zoneSamples {
+		n1, o1 := t.In(loc1).Zone()
+		n2, o2 := t.In(loc2).Zone()
+		if n1 != n2 || o1 != o2 {
+			return false
+		}
+	}
+	return true
+}
+
+// The math/big types have no exported fields. Pointers to them are printed as
+// calls to their constructors. Values are printed by dereferencing those calls.
+
+func (p *Printer) printBigInt(x big.Int) string {
+	return "*" + p.printBigIntPtr(&x)
+}
+
+func (p *Printer) printBigIntPtr(x *big.Int) string {
+	if x == nil {
+		return "(*" + p.qualifiedName("math/big", "Int") + ")(nil)"
+	}
+	if x.IsInt64() {
+		return fmt.Sprintf("%s(%d)", p.qualifiedName("math/big", "NewInt"), x.Int64())
+	}
+	return fmt.Sprintf("func() *%s { x, _ := new(%[1]s).SetString(%q, 10); return x }()",
+		p.qualifiedName("math/big", "Int"), x.String())
+}
+
+func (p *Printer) printBigRat(x big.Rat) string {
+	return "*" + p.printBigRatPtr(&x)
+}
+
+func (p *Printer) printBigRatPtr(x *big.Rat) string {
+	if x == nil {
+		return "(*" + p.qualifiedName("math/big", "Rat") + ")(nil)"
+	}
+	if x.Num().IsInt64() && x.Denom().IsInt64() {
+		return fmt.Sprintf("%s(%d, %d)", p.qualifiedName("math/big", "NewRat"), x.Num().Int64(), x.Denom().Int64())
+	}
+	return fmt.Sprintf("func() *%s { x, _ := new(%[1]s).SetString(%q); return x }()",
+		p.qualifiedName("math/big", "Rat"), x.String())
+}
+
+func (p *Printer) printBigFloat(x big.Float) string {
+	if x.Prec() == 0 && x.Mode() == big.ToNearestEven && !x.IsInf() && !x.Signbit() {
+		// The zero value.
+		return p.qualifiedName("math/big", "Float") + "{}"
+	}
+	return "*" + p.printBigFloatPtr(&x)
+}
+
+// printBigFloatPtr prints a call to big.ParseFloat that produces a Float with the
+// same value, precision and rounding mode as x.
+func (p *Printer) printBigFloatPtr(x *big.Float) string {
+	if x == nil {
+		return "(*" + p.qualifiedName("math/big", "Float") + ")(nil)"
+	}
+	if x.Prec() == 0 && !x.IsInf() {
+		// A zero with no precision, which ParseFloat would give a precision
+		// of 64.
+		s := "new(" + p.qualifiedName("math/big", "Float") + ")"
+		if x.Mode() != big.ToNearestEven {
+			s += fmt.Sprintf(".SetMode(%s)", p.qualifiedName("math/big", x.Mode().String()))
+		}
+		if x.Signbit() {
+			s += ".Neg(new(" + p.qualifiedName("math/big", "Float") + "))"
+		}
+		return s
+	}
+	// Use the shortest decimal representation that parses back to x, if there
+	// is one. Parsing with ToNearestEven ensures that. Otherwise, use the
+	// exact hexadecimal form.
+	text, base := x.Text('g', -1), 10
+	if y, _, err := big.ParseFloat(text, base, x.Prec(), big.ToNearestEven); err != nil || !sameFloat(x, y) {
+		text, base = x.Text('p', 0), 0
+	}
+	parse := fmt.Sprintf("%s(%q, %d, %d, %s)",
+		p.qualifiedName("math/big", "ParseFloat"), text, base, x.Prec(),
+		p.qualifiedName("math/big", big.ToNearestEven.String()))
+	ret := "x"
+	if x.Mode() != big.ToNearestEven {
+		ret = fmt.Sprintf("x.SetMode(%s)", p.qualifiedName("math/big", x.Mode().String()))
+	}
+	return fmt.Sprintf("func() *%s { x, _, _ := %s; return %s }()",
+		p.qualifiedName("math/big", "Float"), parse, ret)
+}
+
+// sameFloat reports whether x and y have the same value, including the sign of zero.
+func sameFloat(x, y *big.Float) bool {
+	return x.Cmp(y) == 0 && x.Signbit() == y.Signbit()
+}
+
+// printIP prints an IP address as a call to net.ParseIP. The result has the
+// same length as ip.
+func (p *Printer) printIP(ip net.IP) string {
+	switch {
+	case ip == nil:
+		return p.qualifiedName("net", "IP") + "(nil)"
+	case len(ip) == net.IPv6len:
+		return fmt.Sprintf("%s(%q)", p.qualifiedName("net", "ParseIP"), ip)
+	case len(ip) == net.IPv4len:
+		return fmt.Sprintf("%s(%q).To4()", p.qualifiedName("net", "ParseIP"), ip)
+	default:
+		// Not a valid IP address. Print the bytes.
+		return p.qualifiedName("net", "IP") + sprintBytes(ip)
+	}
+}
+
+// printIPMask prints an IP mask as a call to net.CIDRMask or net.IPv4Mask,
+// if possible.
+func (p *Printer) printIPMask(m net.IPMask) string {
+	if m == nil {
+		return p.qualifiedName("net", "IPMask") + "(nil)"
+	}
+	if ones, bits := m.Size(); bits != 0 {
+		return fmt.Sprintf("%s(%d, %d)", p.qualifiedName("net", "CIDRMask"), ones, bits)
+	}
+	if len(m) == net.IPv4len {
+		return fmt.Sprintf("%s(%d, %d, %d, %d)", p.qualifiedName("net", "IPv4Mask"), m[0], m[1], m[2], m[3])
+	}
+	return p.qualifiedName("net", "IPMask") + sprintBytes(m)
+}
+
+func (p *Printer) printIPNet(n net.IPNet) string {
+	var fields []string
+	if n.IP != nil {
+		fields = append(fields, "IP: "+p.printIP(n.IP))
+	}
+	if n.Mask != nil {
+		fields = append(fields, "Mask: "+p.printIPMask(n.Mask))
+	}
+	return p.qualifiedName("net", "IPNet") + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// sprintBytes returns the body of a composite literal for a slice of bytes.
+func sprintBytes(b []byte) string {
+	var elems []string
+	for _, x := range b {
+		elems = append(elems, fmt.Sprintf("%#x", x))
+	}
+	return "{" + strings.Join(elems, ", ") + "}"
+}
+
+func (p *Printer) printURL(u url.URL) (string, error) {
+	s, err := p.printURLPtr(&u)
+	if err != nil {
+		return "", err
+	}
+	return "*" + s, nil
+}
+
+// printURLPtr prints a URL as a call to url.Parse. It fails if parsing the
+// URL's string form doesn't produce the same URL.
+func (p *Printer) printURLPtr(u *url.URL) (string, error) {
+	if u == nil {
+		return "(*" + p.qualifiedName("net/url", "URL") + ")(nil)", nil
+	}
+	s := u.String()
+	if u2, err := url.Parse(s); err != nil || !reflect.DeepEqual(u, u2) {
+		return "", fmt.Errorf("cannot print URL %q: url.Parse does not reproduce it", s)
+	}
+	return fmt.Sprintf("func() *%s { u, _ := %s(%q); return u }()",
+		p.qualifiedName("net/url", "URL"), p.qualifiedName("net/url", "Parse"), s), nil
+}
+
+// printRegexp prints a regexp as a call to regexp.MustCompile or
+// regexp.MustCompilePOSIX, whichever reproduces it.
+func (p *Printer) printRegexp(re *regexp.Regexp) (string, error) {
+	if re == nil {
+		return "(*" + p.qualifiedName("regexp", "Regexp") + ")(nil)", nil
+	}
+	expr := re.String()
+	lit := rawQuote(expr)
+	if re2, err := regexp.Compile(expr); err == nil && reflect.DeepEqual(re, re2) {
+		return fmt.Sprintf("%s(%s)", p.qualifiedName("regexp", "MustCompile"), lit), nil
+	}
+	if re2, err := regexp.CompilePOSIX(expr); err == nil && reflect.DeepEqual(re, re2) {
+		return fmt.Sprintf("%s(%s)", p.qualifiedName("regexp", "MustCompilePOSIX"), lit), nil
+	}
+	// The Longest method switches a compiled regexp to leftmost-longest matching.
+	if re2, err := regexp.Compile(expr); err == nil {
+		re2.Longest()
+		if reflect.DeepEqual(re, re2) {
+			return fmt.Sprintf("func() *%s { re := %s(%s); re.Longest(); return re }()",
+				p.qualifiedName("regexp", "Regexp"), p.qualifiedName("regexp", "MustCompile"), lit), nil
+		}
+	}
+	return "", fmt.Errorf("cannot print regexp %s: compiling it does not reproduce it", lit)
+}
+
+// rawQuote returns s as a raw string literal if it can be written as one, and
+// as an interpreted string literal otherwise.
+func rawQuote(s string) string {
+	if strings.Contains(s, `\`) && strconv.CanBackquote(s) {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+// printTemplate prints a text/template Template as a call to Parse.
+func (p *Printer) printTemplate(t *template.Template) (string, error) {
+	if t == nil {
+		return "(*" + p.qualifiedName("text/template", "Template") + ")(nil)", nil
+	}
+	trees := map[string]*parse.Tree{}
+	for _, t2 := range t.Templates() {
+		trees[t2.Name()] = t2.Tree
+	}
+	return p.sprintTemplate("text/template", t.Name(), t.Tree, trees, func(name, left, right, src string) (map[string]*parse.Tree, error) {
+		t2, err := template.New(name).Delims(left, right).Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		m := map[string]*parse.Tree{}
+		for _, t3 := range t2.Templates() {
+			m[t3.Name()] = t3.Tree
+		}
+		return m, nil
+	})
+}
+
+// printHTMLTemplate prints an html/template Template as a call to Parse.
+func (p *Printer) printHTMLTemplate(t *htmltemplate.Template) (string, error) {
+	if t == nil {
+		return "(*" + p.qualifiedName("html/template", "Template") + ")(nil)", nil
+	}
+	trees := map[string]*parse.Tree{}
+	for _, t2 := range t.Templates() {
+		trees[t2.Name()] = t2.Tree
+	}
+	return p.sprintTemplate("html/template", t.Name(), t.Tree, trees, func(name, left, right, src string) (map[string]*parse.Tree, error) {
+		t2, err := htmltemplate.New(name).Delims(left, right).Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		m := map[string]*parse.Tree{}
+		for _, t3 := range t2.Templates() {
+			m[t3.Name()] = t3.Tree
+		}
+		return m, nil
+	})
+}
+
+// sprintTemplate returns an expression that parses a template named name with
+// the given tree, along with the other templates associated with it. The text
+// of each template is recovered from its parse tree, so the functions and
+// options that were used to build the template are not printed. To make sure
+// nothing was lost, the text is parsed again with parseFunc, and the resulting
+// trees must match the originals.
+func (p *Printer) sprintTemplate(pkgPath, name string, tree *parse.Tree, trees map[string]*parse.Tree,
+	parseFunc func(name, left, right, src string) (map[string]*parse.Tree, error)) (string, error) {
+
+	if tree == nil {
+		return "", fmt.Errorf("cannot print template %q: it has not been parsed", name)
+	}
+	var names []string
+	for n, t := range trees {
+		if n != name && t != nil {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	left, right := treeDelims(tree)
+	var b strings.Builder
+	b.WriteString(tree.Root.String())
+	for _, n := range names {
+		fmt.Fprintf(&b, "%sdefine %q%s%s%send%s", left, n, right, trees[n].Root, left, right)
+	}
+	src := b.String()
+	trees2, err := parseFunc(name, left, right, src)
+	if err != nil {
+		return "", fmt.Errorf("cannot print template %q: %v", name, err)
+	}
+	for n, t := range trees {
+		if t == nil {
+			continue
+		}
+		if t2 := trees2[n]; t2 == nil || t2.Root.String() != t.Root.String() {
+			return "", fmt.Errorf("cannot print template %q: parsing its text does not reproduce template %q", name, n)
+		}
+	}
+	delims := ""
+	if left != "{{" || right != "}}" {
+		delims = fmt.Sprintf(".Delims(%q, %q)", left, right)
+	}
+	return fmt.Sprintf("%s(%s(%q)%s.Parse(%q))",
+		p.qualifiedName(pkgPath, "Must"), p.qualifiedName(pkgPath, "New"), name, delims, src), nil
+}
+
+// treeDelims returns the action delimiters that the nodes of a parse tree are
+// written with. The parse package doesn't export them, and older versions
+// always use the default ones.
+func treeDelims(t *parse.Tree) (left, right string) {
+	l := reflect.ValueOf(t).Elem().FieldByName("leftDelim")
+	r := reflect.ValueOf(t).Elem().FieldByName("rightDelim")
+	if !l.IsValid() || !r.IsValid() || l.String() == "" || r.String() == "" {
+		return "{{", "}}"
+	}
+	return l.String(), r.String()
+}
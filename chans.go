@@ -0,0 +1,26 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// printChan prints v, a non-nil channel, as a call to make. Only empty
+// channels can be printed. Whether v is closed can't be found out without
+// receiving from it, so a closed channel prints as an open one.
+func (s *state) printChan(v reflect.Value) {
+	if n := v.Len(); n > 0 {
+		s.err = fmt.Errorf("cannot print a channel of type %s that holds %d buffered elements", v.Type(), n)
+		return
+	}
+	if c := v.Cap(); c > 0 {
+		s.printf("make(%s, %d)", s.sprintType(v.Type()), c)
+	} else {
+		s.printf("make(%s)", s.sprintType(v.Type()))
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// SprintDecls returns Go source for a package-level variable named name whose
+// value is value. See FprintDecls for details.
+func (p *Printer) SprintDecls(name string, value interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := p.FprintDecls(&buf, name, value); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// FprintDecls prints Go source for a package-level variable named name whose
+// value is value.
+//
+// Unlike Fprint, FprintDecls preserves sharing. Each pointer, map, slice or
+// channel that is reached more than once while traversing value is written as
+// a separate variable, and every place it occurs refers to that variable. The
+// names of those variables begin with an underscore followed by name.
+//
+// FprintDecls can also print cyclic values. A reference that would make a
+// variable's initializer depend on itself is printed as nil, and is set instead
+// by an assignment in a func init that follows the declarations.
+func (p *Printer) FprintDecls(w io.Writer, name string, value interface{}) error {
+	return p.writeDecls(w, []string{name}, []interface{}{value})
+}
+
+// writeDecls writes declarations for the variables with the given names and
+// values, sharing values among all of them.
+func (p *Printer) writeDecls(w io.Writer, names []string, values []interface{}) error {
+	d := newDeclState(names)
+	vs := make([]reflect.Value, len(values))
+	for i, value := range values {
+		vs[i] = reflect.ValueOf(value)
+		d.count(p, vs[i], 0)
+	}
+	// Variables holding shared values are printed after all the named ones.
+	srcs := make([]string, len(names))
+	for i, name := range names {
+		src, err := d.printDecl(p, name, vs[i])
+		if err != nil {
+			return err
+		}
+		srcs[i] = src
+	}
+	for i, name := range names {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "var %s = %s\n", name, srcs[i]); err != nil {
+			return err
+		}
+	}
+	for _, dv := range d.vars {
+		if _, err := fmt.Fprintf(w, "\nvar %s = %s\n", dv.name, dv.src); err != nil {
+			return err
+		}
+	}
+	return d.writeInit(w)
+}
+
+// refKey identifies a pointer, map, slice or channel by the memory it refers
+// to.
+type refKey struct {
+	t        reflect.Type
+	ptr      uintptr
+	len, cap int // for slices
+}
+
+// refKeyOf returns the refKey for v, and reports whether v is a value whose
+// sharing should be preserved.
+func refKeyOf(v reflect.Value) (refKey, bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		// Distinct zero-size values may have the same address.
+		if v.IsNil() || v.Type().Elem().Size() == 0 {
+			return refKey{}, false
+		}
+		return refKey{t: v.Type(), ptr: v.Pointer()}, true
+	case reflect.Map, reflect.Chan:
+		if v.IsNil() {
+			return refKey{}, false
+		}
+		return refKey{t: v.Type(), ptr: v.Pointer()}, true
+	case reflect.Slice:
+		if v.IsNil() || v.Cap() == 0 || v.Type().Elem().Size() == 0 {
+			return refKey{}, false
+		}
+		return refKey{t: v.Type(), ptr: v.Pointer(), len: v.Len(), cap: v.Cap()}, true
+	default:
+		return refKey{}, false
+	}
+}
+
+// declState holds the information needed to print a set of variable
+// declarations that share values.
+type declState struct {
+	counts   map[refKey]int      // number of times each value is reached
+	byKey    map[refKey]*declVar // variables for shared values
+	vars     []*declVar          // variables in the order they were created
+	prefix   string              // prefix of generated variable names
+	prefixes map[string]int      // number of variables generated for each prefix
+	taken    map[string]bool     // names of all variables, given and generated
+	fixups   []string            // assignments that complete cycles
+}
+
+// A declVar is a package-level variable holding a shared value.
+type declVar struct {
+	name       string
+	v          reflect.Value
+	src        string // the variable's initializer
+	inProgress bool   // the initializer is being printed
+}
+
+// newDeclState returns a declState for declaring variables with the given
+// names.
+func newDeclState(names []string) *declState {
+	d := &declState{
+		counts:   map[refKey]int{},
+		byKey:    map[refKey]*declVar{},
+		prefixes: map[string]int{},
+		taken:    map[string]bool{},
+	}
+	for _, name := range names {
+		d.taken[name] = true
+	}
+	return d
+}
+
+// newName returns a name for a variable beginning with the current prefix that
+// no other variable has. Names from different prefixes can clash, as "_a11"
+// does for "_a" and "_a1", so the counter skips names that are taken.
+func (d *declState) newName() string {
+	for {
+		d.prefixes[d.prefix]++
+		name := fmt.Sprintf("%s%d", d.prefix, d.prefixes[d.prefix])
+		if !d.taken[name] {
+			d.taken[name] = true
+			return name
+		}
+	}
+}
+
+// count traverses v in the same way that state.print does, counting the number
+// of times each pointer, map and slice is reached.
+func (d *declState) count(p *Printer, v reflect.Value, depth int) {
+	if !v.IsValid() || depth > maxDepth {
+		return
+	}
+	if _, ok := p.lookupKnown(v); ok {
+		return
+	}
+	if k, ok := refKeyOf(v); ok {
+		d.counts[k]++
+		if d.counts[k] > 1 {
+			return
+		}
+	}
+	if p.printFuncs[v.Type()] != nil {
+		return
+	}
+	depth++
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		d.count(p, v.Elem(), depth)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			d.count(p, v.Index(i), depth)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			d.count(p, iter.Key(), depth)
+			d.count(p, iter.Value(), depth)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if p.printableField(v.Type(), i) {
+				d.count(p, v.Field(i), depth)
+			}
+		}
+	}
+}
+
+// printDecl returns the source for the initializer of the variable called name
+// whose value is v. If v is shared, the variable holds it.
+func (d *declState) printDecl(p *Printer, name string, v reflect.Value) (string, error) {
+	var dv *declVar
+	if k, ok := refKeyOf(v); ok && d.counts[k] > 1 {
+		if dv := d.byKey[k]; dv != nil {
+			// An earlier declaration reached this value.
+			return dv.name, nil
+		}
+		dv = &declVar{name: name, v: v}
+		d.byKey[k] = dv
+		dv.inProgress = true
+	}
+	d.prefix = "_" + name
+	var buf bytes.Buffer
+	s := &state{
+		w:        &buf,
+		p:        p,
+		decls:    d,
+		declRoot: dv != nil,
+		declName: name,
+	}
+	s.print(v, nil, false)
+	if dv != nil {
+		dv.inProgress = false
+	}
+	return buf.String(), s.err
+}
+
+// varFor returns the name of the variable for v, creating it if necessary. It
+// reports whether v is shared.
+func (d *declState) varFor(s *state, v reflect.Value) (string, bool) {
+	k, ok := refKeyOf(v)
+	if !ok || d.counts[k] <= 1 {
+		return "", false
+	}
+	if dv := d.byKey[k]; dv != nil {
+		if !dv.inProgress {
+			return dv.name, true
+		}
+		// Referring to dv here would make its initializer depend on itself.
+		// Leave the location nil and assign it in an init function.
+		lv, err := s.lvalue(s.declName)
+		if err != nil {
+			s.err = err
+			return "", true
+		}
+		d.fixups = append(d.fixups, lv+" = "+dv.name)
+		return "nil", true
+	}
+	dv := &declVar{
+		name:       d.newName(),
+		v:          v,
+		inProgress: true,
+	}
+	d.byKey[k] = dv
+	d.vars = append(d.vars, dv)
+
+	var buf bytes.Buffer
+	s2 := &state{
+		w:        &buf,
+		p:        s.p,
+		decls:    d,
+		declRoot: true,
+		declName: dv.name,
+	}
+	s2.print(v, nil, false)
+	if s2.err != nil {
+		s.err = s2.err
+	}
+	dv.src = buf.String()
+	dv.inProgress = false
+	return dv.name, true
+}
+
+// writeInit writes a func init containing the fixups, if there are any.
+func (d *declState) writeInit(w io.Writer) error {
+	if len(d.fixups) == 0 {
+		return nil
+	}
+	if _, err := io.WriteString(w, "\nfunc init() {\n"); err != nil {
+		return err
+	}
+	for _, f := range d.fixups {
+		if _, err := fmt.Fprintf(w, "\t%s\n", f); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// lvalue returns an expression denoting the location of the value being
+// printed, starting from the variable root. It returns an error if the
+// location cannot be assigned to.
+func (s *state) lvalue(root string) (string, error) {
+	var (
+		e           = root
+		addressable = true  // variables are addressable
+		mapIndex    = false // e is a map index expression
+	)
+	// Assigning to an interface does not require a type assertion.
+	path := s.path
+	for len(path) > 0 && path[len(path)-1].kind == pathAssert {
+		path = path[:len(path)-1]
+	}
+	for i, pe := range path {
+		if mapIndex {
+			return "", fmt.Errorf("cannot assign to a part of map element %s", e)
+		}
+		switch pe.kind {
+		case pathField:
+			if !addressable {
+				return "", fmt.Errorf("cannot assign to a field of %s", e)
+			}
+			e += "." + pe.name
+		case pathIndex:
+			if pe.typ.Kind() == reflect.Slice {
+				addressable = true
+			} else if !addressable {
+				return "", fmt.Errorf("cannot assign to an element of %s", e)
+			}
+			e += fmt.Sprintf("[%d]", pe.index)
+		case pathMapKey:
+			return "", errors.New("cannot print a cycle through a map key")
+		case pathMapValue:
+			if !reflexive(pe.key) {
+				return "", fmt.Errorf("cannot assign to the element of %s with key %s", e, pe.key)
+			}
+			e += "[" + s.sprint(pe.key, pe.typ, false) + "]"
+			mapIndex = true
+		case pathDeref:
+			// A selector automatically dereferences a pointer to a struct.
+			if i+1 >= len(path) || path[i+1].kind != pathField {
+				e = "(*" + e + ")"
+			}
+			addressable = true
+		case pathAssert:
+			e += ".(" + s.sprintType(pe.typ) + ")"
+			addressable = false
+		}
+	}
+	if !addressable && !mapIndex {
+		return "", fmt.Errorf("cannot assign to %s", e)
+	}
+	return e, nil
+}
@@ -19,6 +19,16 @@ To generate code for a slice of Points in package "geo":
        }
    }
 
+Generating Files
+
+A File builds a complete Go source file from named values. It writes the
+comment that marks the file as generated, the package clause, the imports the
+values need and a variable declaration for each value, and formats the result
+with gofmt:
+
+   src, err := p.NewFile("gen.go").Var("points", data).Source()
+
+
 Registering Import Path Identifiers
 
 To print the names of a type in another package, printsrc needs to know how to
@@ -29,8 +39,14 @@ doesn't hold for all packages. The actual identifier is the package name as
 declared in its files' package clause, which need not be the same as the last
 component of the import path. (A common case: import paths ending in "/v2",
 "/v3" and so on.) Also, an import statement can specify a different identifier.
-Since printsrc can't know about these special cases, you must call
+By default printsrc doesn't know about these special cases, so you must call
 Printer.RegisterImport to tell it the identifier to use for a given import path.
+Alternatively, call Printer.ResolvePackageNames to have printsrc read each
+package's source to find its name.
+
+A Printer remembers the packages that the source it prints refers to. Call
+Printer.Imports after printing to get the import paths and identifiers needed
+to write the import declarations of the generated file.
 
 
 Registering Custom Printers
@@ -43,22 +59,130 @@ constructed with the NewInt function or the SetString method.)
 Use Printer.RegisterPrinter to associate a type with a function that returns
 source code for a value of that type.
 
-A custom printer for time.Time is registered by default. It prints a time.Time
-by printing a call to time.Date. An error is returned if the time's location is
-not Local or UTC, since those are the only locations for which source
-expressions can be produced.
+A struct that can't be printed as a literal, but that implements
+encoding.TextMarshaler or encoding.BinaryMarshaler and the corresponding
+unmarshaler, can be printed as an expression that unmarshals the value. Call Printer.UseMarshalers to enable
+that.
+
+Custom printers for the Int, Rat and Float types of math/big, and pointers to
+them, are registered by default. They print calls to constructors like
+big.NewInt. A big.Float keeps its precision and rounding mode.
+
+Network addresses also have default printers. A net.IP prints as a call to
+net.ParseIP, a net/netip.Addr as a call to netip.MustParseAddr, and similarly
+for net.IPMask, net.IPNet, netip.AddrPort and netip.Prefix. A url.URL prints as
+a call to url.Parse.
+
+A *regexp.Regexp prints as a call to regexp.MustCompile, or to
+regexp.MustCompilePOSIX if that is how it was compiled. Pointers to the Template
+types of text/template and html/template print as a call to Parse on text that
+is recovered from the templates' parse trees. Template functions are not
+printed, so a template that calls functions other than the predefined ones
+cannot be printed. An html/template Template cannot be printed after it has
+been executed. Both template packages are named "template", so call
+Printer.Import to give one of them a different identifier if the
+printed code refers to both.
+
+A custom printer for time.Time is also registered by default. It prints a time.Time
+by printing a call to time.Date. The location is printed as time.UTC or
+time.Local, as a call to time.FixedZone for a zone with a fixed offset, or as a
+call to time.LoadLocation for a zone from the time zone database. Times in
+time.Local depend on the machine that runs the generated code; call
+Printer.LocalTimesIn to convert them to a fixed location instead. A
+time.Duration prints as a constant expression in the units of the time package,
+like 90 * time.Second or 1*time.Hour + 30*time.Minute.
+
+Errors created by errors.New print as calls to errors.New, and errors created by
+fmt.Errorf print as calls to fmt.Errorf, with a %w verb for each error they
+wrap. Call Printer.Sentinel to have an error variable like io.EOF printed as a
+reference to the variable.
+
+A reflect.Type prints as an expression like reflect.TypeOf((*T)(nil)).Elem(),
+where T is written the same way as the types of composite literals.
+
+
+Referring to Known Variables
+
+Some values are best printed as references to existing package-level variables.
+Call Printer.KnownVar with the address of a variable, like &http.DefaultClient.
+Then a pointer, map or slice that refers to the same memory as the variable's
+value prints as the variable's name, and a pointer to the variable itself prints
+as its address. Call Printer.KnownValue to print any value equal to a given one,
+like a configuration default, as a name.
+
+Function values can't be printed, because there is no way to write the source
+of a function. But a top-level function can be referred to by name. Call
+Printer.UseFuncNames to print functions like strings.ToUpper as their names.
+
+An empty channel prints as a call to make with the channel's type and capacity,
+like make(chan int, 10). A channel that holds buffered elements cannot be
+printed. Printing never receives from a channel, so it can't tell whether the
+channel is closed; a closed channel prints the same as an open one.
+
+A map with keys that are not equal to themselves, like NaNs, prints as a func
+literal that builds the map, adding the entries with those keys one at a time.
 
 
 Registering Less Functions
 
 This package makes an effort to sort map keys in order to generate deterministic
-output. But if it can't sort the keys it prints the map anyway. The output
-will be valid Go but the order of the keys will change from run to run.
-That creates noise in code review diffs.
+output. Besides numbers, strings and booleans, it sorts structs field by field,
+arrays element by element, pointers by the values they point to, and interface
+values by the name of their dynamic type and then by value. That doesn't order
+every set of keys: interface values holding channels, or distinct pointers to
+equal values, can't be told apart. If it can't sort the keys it prints the map
+anyway. The output will be valid Go but the order of
+the keys will change from run to run. That creates noise in code review diffs.
 
 Use Printer.RegisterLess to register a function that compares two values
 of a type. It will be called to sort map keys of that type.
 
+Call Printer.RequireSortedKeys to make printing fail instead, with an error that
+says where the map is, when it reaches a map whose keys can't be sorted.
+
+
+Preserving Sharing
+
+Printer.FprintDecls prints a value as a package-level variable declaration.
+Every pointer, map, slice or channel that is reached more than once is written
+as its own variable, and each occurrence refers to that variable. For example,
+given
+
+   n := &Node{Name: "a"}
+   p.FprintDecls(w, "nodes", []*Node{n, n})
+
+the output is
+
+   var nodes = []*Node{_nodes1, _nodes1}
+
+   var _nodes1 = &Node{Name: "a"}
+
+FprintDecls also prints cyclic values, such as trees with parent pointers. The
+reference that closes each cycle is left nil in the variable's initializer and
+is assigned in a func init that follows the declarations.
+
+
+Printing Pointers
+
+A pointer to a value that prints as a composite literal, like a struct, is
+printed by taking the address of the literal. A pointer to any other value,
+like a number or the result of a custom printer, is printed as a func literal
+that returns the address of a variable, like
+
+   func() *int { var x int = 5; return &x }()
+
+For code that will be built with Go 1.18 or later, call Printer.PointerHelper
+to print a call to a generic function instead, like ptr(5). A File declares the
+function if it uses it.
+
+
+Targeting a Go Version
+
+By default, printed code can be built with Go 1.16. Call Printer.GoVersion with
+the version in the go.mod file of the module that the code is for, to use newer
+syntax and library functions where they help, like any for interface{}.
+ModuleGoVersion reads that version from a go.mod file.
+
 
 Type Elision
 
@@ -74,14 +198,15 @@ will print in its simplified form,
 
 Known Issues
 
-Maps with multiple NaN keys are not handled.
-
 The reflect package provides no way to distinguish a type defined inside a
 function from one at top level. So printsrc will print expressions containing
 names for those types which will not compile.
 
-Sharing relationships are not preserved. For example, if two pointers in the input
-point to the same value, they will point to different values in the output.
+Sharing relationships are not preserved by Fprint and Sprint. For example, if
+two pointers in the input point to the same value, they will point to different
+values in the output. Use Printer.FprintDecls to preserve sharing. It only
+detects pointers, maps and slices that are identical; a pointer into the middle
+of a shared value, or a slice of part of a shared slice, is still copied.
 
 Unexported fields of structs defined outside the generated package are ignored,
 because there is no way to set them (without using unsafe code). So important
@@ -90,8 +215,10 @@ to print a non-zero struct from outside the generated package with no exported
 fields. You must register custom printers for such structs. But that won't catch
 problems with types that have at least one exported field.
 
-Cycles are detected by the crude heuristic of limiting recursion depth. Cycles
-cause printsrc to fail. A more sophisticated approach would represent cyclical
-values using intermediate variables, but it doesn't seem worth it.
+Fprint and Sprint detect cycles by the crude heuristic of limiting recursion
+depth, and fail on them. Printer.FprintDecls tracks pointer identity instead, so
+it finds real cycles and can print them: the references that close cycles are
+assigned in a func init. It cannot print a cycle that passes through a map key, or
+through a map element or interface value that is not a pointer.
 */
 package printsrc
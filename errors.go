@@ -0,0 +1,96 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Sentinel tells the Printer that err is the value of the package-level
+// variable with the given name in the package with the given import path, like
+// io.EOF. An error equal to err is printed as a reference to the variable. It
+// is the same as KnownValue, and panics if err is not comparable.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) Sentinel(pkgPath, name string, err error) *Printer {
+	return p.KnownValue(pkgPath, name, err)
+}
+
+var (
+	tErrorString = reflect.TypeOf(errors.New(""))
+	tWrapError   = reflect.TypeOf(fmt.Errorf("%w", errors.New("")))
+)
+
+// errorPrinters holds the types of the errors that printError prints. Files
+// with build constraints add the types that only some versions of Go have.
+var errorPrinters = map[reflect.Type]bool{tErrorString: true, tWrapError: true}
+
+// printError prints v if it is an error created by errors.New or fmt.Errorf.
+// It reports whether it printed v.
+//
+// An error from errors.New prints as a call to errors.New. One from fmt.Errorf
+// that wraps other errors prints as a call to fmt.Errorf whose format string
+// has a %w verb in place of the text of each wrapped error.
+func (s *state) printError(v reflect.Value) bool {
+	t := v.Type()
+	if t.Kind() == reflect.Interface || !t.Implements(tError) || !v.CanInterface() {
+		return false
+	}
+	if !errorPrinters[t] || v.IsNil() {
+		return false
+	}
+	err := v.Interface().(error)
+	var wrapped []error
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		wrapped = e.Unwrap()
+	case interface{ Unwrap() error }:
+		wrapped = []error{e.Unwrap()}
+	}
+	if len(wrapped) == 0 {
+		s.printf("%s(%q)", s.p.qualifiedName("errors", "New"), err.Error())
+		return true
+	}
+	if len(wrapped) > 1 && !s.p.goAtLeast(20) {
+		s.err = fmt.Errorf("cannot print error %q: wrapping more than one error requires Go 1.20", err)
+		return true
+	}
+	format, ok := wrapFormat(err.Error(), wrapped)
+	if !ok {
+		s.err = fmt.Errorf("cannot print error %q: its text does not contain the text of the errors it wraps", err)
+		return true
+	}
+	s.printf("%s(%q", s.p.qualifiedName("fmt", "Errorf"), format)
+	for _, w := range wrapped {
+		s.printString(", ")
+		s.print(reflect.ValueOf(w), nil, false)
+	}
+	s.printString(")")
+	return true
+}
+
+// wrapFormat returns a format string for fmt.Errorf that produces msg when
+// given the wrapped errors as arguments.
+func wrapFormat(msg string, wrapped []error) (string, bool) {
+	var b strings.Builder
+	for _, w := range wrapped {
+		if w == nil {
+			return "", false
+		}
+		i := strings.Index(msg, w.Error())
+		if i < 0 {
+			return "", false
+		}
+		b.WriteString(strings.ReplaceAll(msg[:i], "%", "%%"))
+		b.WriteString("%w")
+		msg = msg[i+len(w.Error()):]
+	}
+	b.WriteString(strings.ReplaceAll(msg, "%", "%%"))
+	return b.String(), true
+}
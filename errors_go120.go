@@ -0,0 +1,17 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.20
+// +build go1.20
+
+package printsrc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+func init() {
+	// Starting with Go 1.20, fmt.Errorf can wrap more than one error.
+	errorPrinters[reflect.TypeOf(fmt.Errorf("%w%w", errors.New(""), errors.New("")))] = true
+}
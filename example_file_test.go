@@ -0,0 +1,51 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc_test
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jba/printsrc"
+)
+
+// This example shows how to generate a complete Go source file.
+func ExampleFile() {
+	p := printsrc.NewPrinter("github.com/jba/printsrc/example")
+	src, err := p.NewFile("example_file_test.go").
+		Var("startTime", time.Date(2021, 12, 21, 14, 32, 11, 00, time.Local)).
+		Var("strings", []sql.NullString{
+			{String: "ok", Valid: true},
+			{String: "", Valid: false},
+		}).
+		Source()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s", src)
+
+	// Output:
+	// // Code generated by example_file_test.go. DO NOT EDIT.
+	//
+	// package example
+	//
+	// import (
+	// 	"database/sql"
+	// 	"time"
+	// )
+	//
+	// var startTime = time.Date(2021, time.December, 21, 14, 32, 11, 0, time.Local)
+	//
+	// var strings = []sql.NullString{
+	// 	{
+	// 		String: "ok",
+	// 		Valid:  true,
+	// 	},
+	// 	{},
+	// }
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A File is a Go source file that declares package-level variables.
+type File struct {
+	p         *Printer
+	generator string
+	names     []string
+	values    []interface{}
+}
+
+// NewFile returns a File whose values are printed by p. The File's package
+// is the one whose import path was passed to NewPrinter. The generator
+// argument appears in the comment at the top of the file that marks it as
+// generated; it is usually the name of the program writing the file.
+func (p *Printer) NewFile(generator string) *File {
+	return &File{p: p, generator: generator}
+}
+
+// Var adds a declaration for a variable with the given name and value to the file.
+// It returns its receiver to support chaining.
+func (f *File) Var(name string, value interface{}) *File {
+	f.names = append(f.names, name)
+	f.values = append(f.values, value)
+	return f
+}
+
+// Source returns the formatted source of the file. It consists of a comment
+// saying that the file is generated, a package clause, declarations for the
+// imports that the variables need, the variables themselves, and the function
+// named by Printer.PointerHelper if the variables use it. If no pointer helper
+// is set and Printer.GoVersion is at least 1.18, the helper is named ptr.
+//
+// The variables are printed as if by Printer.FprintDecls, so values shared
+// among them, and cycles, are preserved.
+//
+// Source returns an error if a variable's name is not an identifier or is used
+// by another variable, if two imported packages have the same identifier, or
+// if a variable has the same name as an imported package's identifier or the
+// pointer helper.
+func (f *File) Source() ([]byte, error) {
+	seen := map[string]bool{}
+	for _, name := range f.names {
+		if !token.IsIdentifier(name) {
+			return nil, fmt.Errorf("variable name %q is not an identifier", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("variable %s is declared more than once", name)
+		}
+		seen[name] = true
+	}
+	// Collect only the imports used by this file.
+	f.p.mu.Lock()
+	used, ptrHelperUsed := f.p.used, f.p.ptrHelperUsed
+	f.p.used, f.p.ptrHelperUsed = map[string]bool{}, false
+	f.p.mu.Unlock()
+	defer func() {
+		f.p.mu.Lock()
+		defer f.p.mu.Unlock()
+		for pkgPath := range f.p.used {
+			used[pkgPath] = true
+		}
+		f.p.used = used
+		f.p.ptrHelperUsed = f.p.ptrHelperUsed || ptrHelperUsed
+	}()
+	// Unlike Sprint's, the File's output can use the helper without setting
+	// it up, because the File declares it.
+	if f.p.ptrHelper == "" && f.p.goAtLeast(18) {
+		f.p.ptrHelper = "ptr"
+		defer func() { f.p.ptrHelper = "" }()
+	}
+
+	var body bytes.Buffer
+	if err := f.p.writeDecls(&body, f.names, f.values); err != nil {
+		return nil, err
+	}
+	if f.p.ptrHelperUsed {
+		fmt.Fprintf(&body, "\n%s", f.p.pointerHelperDecl())
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by %s. DO NOT EDIT.\n\n", f.generator)
+	fmt.Fprintf(&buf, "package %s\n\n", f.p.packageName(f.p.pkgPath))
+	imports := f.p.Imports()
+	var paths []string
+	for pkgPath := range imports {
+		paths = append(paths, pkgPath)
+	}
+	sort.Strings(paths)
+	// The body refers to each package by its identifier, so the identifiers
+	// must be distinct, and must not be hidden by the variables.
+	pathsByIdent := map[string]string{}
+	for _, pkgPath := range paths {
+		ident := imports[pkgPath]
+		if other, ok := pathsByIdent[ident]; ok {
+			return nil, fmt.Errorf("packages %q and %q are both referred to as %s; call Printer.Import to give one a different identifier",
+				other, pkgPath, ident)
+		}
+		pathsByIdent[ident] = pkgPath
+	}
+	for _, name := range f.names {
+		if pkgPath, ok := pathsByIdent[name]; ok {
+			return nil, fmt.Errorf("variable %s has the same name as the identifier of imported package %q", name, pkgPath)
+		}
+		if f.p.ptrHelperUsed && name == f.p.ptrHelper {
+			return nil, fmt.Errorf("variable %s has the same name as the pointer helper", name)
+		}
+	}
+	var specs []string
+	for _, pkgPath := range paths {
+		if ident := imports[pkgPath]; ident != f.p.packageName(pkgPath) {
+			specs = append(specs, fmt.Sprintf("%s %q", ident, pkgPath))
+		} else {
+			specs = append(specs, fmt.Sprintf("%q", pkgPath))
+		}
+	}
+	switch len(specs) {
+	case 0:
+	case 1:
+		fmt.Fprintf(&buf, "import %s\n\n", specs[0])
+	default:
+		fmt.Fprintf(&buf, "import (\n\t%s\n)\n\n", strings.Join(specs, "\n\t"))
+	}
+	buf.Write(body.Bytes())
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated source: %w", err)
+	}
+	return src, nil
+}
+
+// Write writes the source of the file to w.
+func (f *File) Write(w io.Writer) error {
+	src, err := f.Source()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
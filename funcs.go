@@ -0,0 +1,80 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UseFuncNames tells the Printer to print non-nil function values as references
+// to the top-level functions they are, like strings.ToUpper. The function is
+// found with runtime.FuncForPC. Closures, method values and instantiated
+// generic functions have no name that can be referred to, so printing them
+// fails.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) UseFuncNames() *Printer {
+	p.useFuncNames = true
+	return p
+}
+
+// printFuncName prints v, a non-nil func, as the name of a top-level function.
+func (s *state) printFuncName(v reflect.Value, imputedType reflect.Type) {
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		s.err = fmt.Errorf("cannot find the function for a value of type %s", v.Type())
+		return
+	}
+	pkgPath, name, err := splitFuncName(f.Name())
+	if err != nil {
+		s.err = err
+		return
+	}
+	if pkgPath == "main" {
+		s.err = fmt.Errorf("cannot refer to %s: functions in package main cannot be imported", f.Name())
+		return
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsUpper(r) && pkgPath != s.p.pkgPath {
+		s.err = fmt.Errorf("cannot refer to unexported function %s", f.Name())
+		return
+	}
+	src := s.p.qualifiedName(pkgPath, name)
+	if v.Type().Name() != "" && imputedType != v.Type() {
+		src = fmt.Sprintf("%s(%s)", s.sprintType(v.Type()), src)
+	}
+	s.printString(src)
+}
+
+// splitFuncName splits the name of a function, as reported by the runtime,
+// into an import path and a name. It fails if the function is not a top-level
+// function.
+func splitFuncName(fname string) (pkgPath, name string, err error) {
+	// The last element of the import path can contain dots, but the runtime
+	// escapes them.
+	i := strings.LastIndexByte(fname, '/') + 1
+	dot := strings.IndexByte(fname[i:], '.')
+	if dot < 0 {
+		return "", "", fmt.Errorf("cannot parse function name %q", fname)
+	}
+	pkgPath, name = fname[:i+dot], fname[i+dot+1:]
+	if strings.ContainsAny(name, ".[(-") {
+		// Closures are named like F.func1, methods like T.M or (*T).M, method
+		// values like T.M-fm, and generic functions like F[...].
+		return "", "", fmt.Errorf("cannot refer to %s: it is not a top-level function", fname)
+	}
+	pkgPath, err = url.PathUnescape(pkgPath)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot parse function name %q: %v", fname, err)
+	}
+	return pkgPath, name, nil
+}
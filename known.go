@@ -0,0 +1,89 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A knownVar is a package-level variable that printed values can refer to.
+type knownVar struct {
+	pkgPath, name string
+	addr          bool // refer to the variable's address, not its value
+}
+
+// KnownVar tells the Printer about the package-level variable with the given
+// name in the package with the given import path. The last argument must be a
+// pointer to the variable, like &config.Default or &time.UTC.
+//
+// A pointer equal to addr prints as the address of the variable, like
+// &config.Default. If the variable holds a pointer, map or slice, then a value
+// that refers to the same memory prints as the variable itself, like time.UTC.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) KnownVar(pkgPath, name string, addr interface{}) *Printer {
+	v := reflect.ValueOf(addr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		panic(fmt.Sprintf("printsrc: KnownVar %s.%s: need a non-nil pointer, got %T", pkgPath, name, addr))
+	}
+	if k, ok := refKeyOf(v); ok {
+		p.knownRefs[k] = knownVar{pkgPath: pkgPath, name: name, addr: true}
+	}
+	if k, ok := refKeyOf(v.Elem()); ok {
+		p.knownRefs[k] = knownVar{pkgPath: pkgPath, name: name}
+	}
+	return p
+}
+
+// KnownValue tells the Printer that value is the value of the package-level
+// variable or constant with the given name in the package with the given import
+// path. Any value of the same type that is equal to value prints as the name.
+// KnownValue panics if value is not comparable.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) KnownValue(pkgPath, name string, value interface{}) *Printer {
+	if value == nil || !reflect.TypeOf(value).Comparable() {
+		panic(fmt.Sprintf("printsrc: KnownValue %s.%s: %T is not comparable", pkgPath, name, value))
+	}
+	p.knownValues[value] = knownVar{pkgPath: pkgPath, name: name}
+	return p
+}
+
+// knownName returns the source for a known variable that v refers to or is
+// equal to, if there is one.
+func (p *Printer) knownName(v reflect.Value) (string, bool) {
+	kv, ok := p.lookupKnown(v)
+	if !ok {
+		return "", false
+	}
+	name := p.qualifiedName(kv.pkgPath, kv.name)
+	if kv.addr {
+		name = "&" + name
+	}
+	return name, true
+}
+
+func (p *Printer) lookupKnown(v reflect.Value) (kv knownVar, ok bool) {
+	if k, isRef := refKeyOf(v); isRef {
+		kv, ok = p.knownRefs[k]
+		if ok {
+			return kv, true
+		}
+	}
+	if len(p.knownValues) == 0 || v.Kind() == reflect.Interface || !v.CanInterface() || !v.Type().Comparable() {
+		return knownVar{}, false
+	}
+	// A comparable type, like a struct with an interface field, can still
+	// hold a value that can't be hashed.
+	defer func() {
+		if recover() != nil {
+			kv, ok = knownVar{}, false
+		}
+	}()
+	kv, ok = p.knownValues[v.Interface()]
+	return kv, ok
+}
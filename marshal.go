@@ -0,0 +1,86 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+)
+
+// UseMarshalers tells the Printer to print values that it can't otherwise
+// print, but that can marshal and unmarshal themselves, as an expression that
+// unmarshals the value. Those are structs that are not zero, but whose
+// printable fields are, like structs with only unexported fields, and pointers
+// to them. A value of type T qualifies if T or *T implements
+// encoding.TextMarshaler and *T implements encoding.TextUnmarshaler, or
+// similarly for encoding.BinaryMarshaler and encoding.BinaryUnmarshaler. The
+// text methods are preferred. For example, with UseMarshalers a pointer to a
+// geo.Point whose fields are unexported may print as
+//
+//   func() *geo.Point { x := new(geo.Point); if err := x.UnmarshalText([]byte("1,2")); err != nil { panic(err) }; return x }()
+//
+// It returns its receiver to support chaining.
+func (p *Printer) UseMarshalers() *Printer {
+	p.useMarshalers = true
+	return p
+}
+
+var (
+	tTextMarshaler     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	tTextUnmarshaler   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	tBinaryMarshaler   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	tBinaryUnmarshaler = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// printMarshaled prints v as an expression that unmarshals it, if it can.
+// It reports whether it printed v.
+func (s *state) printMarshaled(v reflect.Value) bool {
+	t := v.Type()
+	if t.Kind() == reflect.Interface || !v.CanInterface() {
+		return false
+	}
+	// ptr is a pointer to the value. If v is itself a pointer, its methods
+	// are used directly.
+	var ptr reflect.Value
+	if t.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		ptr = v
+	} else {
+		ptr = reflect.New(t)
+		ptr.Elem().Set(v)
+	}
+	var (
+		data   []byte
+		err    error
+		method string
+	)
+	switch {
+	case ptr.Type().Implements(tTextMarshaler) && ptr.Type().Implements(tTextUnmarshaler):
+		data, err = ptr.Interface().(encoding.TextMarshaler).MarshalText()
+		method = "UnmarshalText"
+	case ptr.Type().Implements(tBinaryMarshaler) && ptr.Type().Implements(tBinaryUnmarshaler):
+		data, err = ptr.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		method = "UnmarshalBinary"
+	default:
+		return false
+	}
+	if err != nil {
+		s.err = fmt.Errorf("marshaling %s: %w", t, err)
+		return true
+	}
+	var decl string
+	if t.Kind() == reflect.Ptr {
+		decl = fmt.Sprintf("x := new(%s)", s.sprintType(t.Elem()))
+	} else {
+		decl = fmt.Sprintf("var x %s", s.sprintType(t))
+	}
+	s.printf("func() %s { %s; if err := x.%s([]byte(%q)); err != nil { panic(err) }; return x }()",
+		s.sprintType(t), decl, method, data)
+	return true
+}
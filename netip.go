@@ -0,0 +1,46 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.18
+// +build go1.18
+
+package printsrc
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+func init() {
+	versionPrintFuncs = append(versionPrintFuncs,
+		func(p *Printer) interface{} { return p.printAddr },
+		func(p *Printer) interface{} { return p.printAddrPort },
+		func(p *Printer) interface{} { return p.printPrefix },
+	)
+}
+
+// The net/netip types are printed as calls to their Must functions. Their zero
+// values, which are not valid, are printed as empty composite literals.
+
+func (p *Printer) printAddr(a netip.Addr) string {
+	if !a.IsValid() {
+		return p.qualifiedName("net/netip", "Addr") + "{}"
+	}
+	return fmt.Sprintf("%s(%q)", p.qualifiedName("net/netip", "MustParseAddr"), a)
+}
+
+func (p *Printer) printAddrPort(ap netip.AddrPort) string {
+	if ap == (netip.AddrPort{}) {
+		return p.qualifiedName("net/netip", "AddrPort") + "{}"
+	}
+	return fmt.Sprintf("%s(%q)", p.qualifiedName("net/netip", "MustParseAddrPort"), ap)
+}
+
+func (p *Printer) printPrefix(pfx netip.Prefix) (string, error) {
+	if pfx == (netip.Prefix{}) {
+		return p.qualifiedName("net/netip", "Prefix") + "{}", nil
+	}
+	if !pfx.IsValid() {
+		return "", fmt.Errorf("cannot print invalid netip.Prefix %s", pfx)
+	}
+	return fmt.Sprintf("%s(%q)", p.qualifiedName("net/netip", "MustParsePrefix"), pfx), nil
+}
@@ -9,47 +9,90 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
 	"io"
 	"math"
+	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // A Printer prints Go values as source code.
+//
+// Once a Printer is configured, its methods that print may be called
+// concurrently, except for the methods of a File, which must not run at the
+// same time as any other printing with the same Printer.
 type Printer struct {
 	pkgPath    string
 	imports    map[string]string // from package path to identifier
 	printFuncs map[reflect.Type]printFunc
 	lessFuncs  map[reflect.Type]lessFunc
+
+	mu            sync.Mutex                 // guards the fields below, which printing changes
+	used          map[string]bool            // import paths of packages referred to
+	names         map[string]string          // resolved package names, by import path
+	ptrHelperUsed bool                       // the pointer helper was printed
+	locForms      map[*time.Location]locForm // how locations are printed
+
+	knownRefs   map[refKey]knownVar      // variables, by the memory they refer to
+	knownValues map[interface{}]knownVar // variables, by value
+
+	useMarshalers bool           // print values by unmarshaling them
+	useFuncNames  bool           // print funcs as the names of functions
+	sortedKeys    bool           // fail on maps whose keys can't be sorted
+	ptrHelper     string         // name of the generic function returning a pointer
+	goMinor       int            // minor version of Go for printed code, or 0
+	localIn       *time.Location // location to print local times in
+
+	resolve    bool   // look up package names
+	resolveDir string // directory to resolve import paths from
 }
 
 // NewPrinter constructs a Printer. The argument is the import path of the
 // package where the printed code will reside.
 //
-// A custom printer for time.Time is registered by default. To override
-// it or to add custom printers for other types, call RegisterPrinter.
+// Custom printers for some standard library types are registered by default:
+// time.Time, *time.Location and time.Duration; the Int, Rat and Float types
+// of math/big (and pointers to them); the IP, IPMask and IPNet types of net;
+// the Addr, AddrPort and Prefix types of net/netip; url.URL (and a pointer to
+// it); *regexp.Regexp; and pointers to the Template types of text/template and
+// html/template. To override them or to add custom printers for other types,
+// call RegisterPrinter.
 func NewPrinter(packagePath string) *Printer {
 	p := &Printer{
 		pkgPath:    packagePath,
 		imports:    map[string]string{},
 		printFuncs: map[reflect.Type]printFunc{},
 		lessFuncs:  map[reflect.Type]lessFunc{},
+		used:       map[string]bool{},
+		names:      map[string]string{},
+		locForms:   map[*time.Location]locForm{},
+
+		knownRefs:   map[refKey]knownVar{},
+		knownValues: map[interface{}]knownVar{},
 	}
-	return p.PrintFuncs(func(t time.Time) (string, error) {
-		loc := t.Location()
-		if loc != time.Local && loc != time.UTC {
-			return "", fmt.Errorf("don't know how to represent location %q in source", loc)
-		}
-		ident := p.PackageIdentifier("time")
-		return fmt.Sprintf("%s.Date(%d, %[1]s.%[3]s, %d, %d, %d, %d, %d, %[1]s.%[9]s)",
-				ident,
-				t.Year(), t.Month(), t.Day(),
-				t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc),
-			nil
-	})
+	p.PrintFuncs(
+		p.printTime, p.printLocation, p.printDuration,
+		p.printBigInt, p.printBigIntPtr,
+		p.printBigRat, p.printBigRatPtr,
+		p.printBigFloat, p.printBigFloatPtr,
+		p.printIP, p.printIPMask, p.printIPNet,
+		p.printURL, p.printURLPtr,
+		p.printRegexp, p.printTemplate, p.printHTMLTemplate,
+	)
+	for _, f := range versionPrintFuncs {
+		p.PrintFuncs(f(p))
+	}
+	return p
 }
 
 // Import tells the Printer to use the given identifier when
@@ -63,18 +106,127 @@ func (p *Printer) Import(packagePath, ident string) *Printer {
 // PackageIdentifier returns the identifier that should prefix type names from
 // the given import path. It returns the empty string if pkgPath is the same as
 // the path given to NewPrinter. Otherwise, if an identifier has been provided
-// with RegisterImport, it uses that. Finally, it returns the last component of
-// the import path.
+// with RegisterImport, it uses that. Finally, it returns the package's name:
+// either the name found by ResolvePackageNames, or the last component of the
+// import path.
+//
+// PackageIdentifier records that the package is used, so custom print
+// functions should call it for each package their output refers to.
 func (p *Printer) PackageIdentifier(pkgPath string) string {
 	if pkgPath == p.pkgPath {
 		return ""
 	}
+	p.mu.Lock()
+	p.used[pkgPath] = true
+	p.mu.Unlock()
 	if ident, ok := p.imports[pkgPath]; ok {
 		return ident
 	}
-	// Assume the package identifier is the last component of the package path.
-	// That is not always correct, which is why Printer.RegisterImport can override it.
-	return path.Base(pkgPath)
+	return p.packageName(pkgPath)
+}
+
+// ResolvePackageNames tells the Printer to find the name of each package it
+// refers to by reading the package's source, instead of assuming that the name
+// is the last component of the import path. That assumption fails for import
+// paths like "example.com/mod/v2" and "gopkg.in/yaml.v3", and for packages
+// whose name differs from their directory.
+//
+// Import paths are resolved as the go command would resolve them from within
+// dir, so the packages must be in GOROOT, the main module or the local module
+// cache. If dir is empty, the current directory is used. When a package cannot
+// be found, the last component of its import path is used as its name.
+// Names are looked up once and remembered.
+//
+// Packages outside GOROOT are found by running "go list" without network
+// access, so modules that are missing from the module cache are not
+// downloaded.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) ResolvePackageNames(dir string) *Printer {
+	// Fix the directory now, in case the working directory changes.
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	p.resolve = true
+	p.resolveDir = dir
+	return p
+}
+
+// packageName returns the name of the package with the given import path.
+func (p *Printer) packageName(pkgPath string) string {
+	if !p.resolve {
+		// Assume the package name is the last component of the package path.
+		// That is not always correct, which is why Printer.Import and
+		// Printer.ResolvePackageNames can override it.
+		return path.Base(pkgPath)
+	}
+	p.mu.Lock()
+	name, ok := p.names[pkgPath]
+	p.mu.Unlock()
+	if ok {
+		return name
+	}
+	name = lookupPackageName(pkgPath, p.resolveDir)
+	if name == "" {
+		name = path.Base(pkgPath)
+	}
+	p.mu.Lock()
+	p.names[pkgPath] = name
+	p.mu.Unlock()
+	return name
+}
+
+// lookupPackageName returns the name of the package with the given import
+// path, as found from dir, or the empty string if the package can't be found.
+// It never uses the network.
+func lookupPackageName(pkgPath, dir string) string {
+	// A package in GOROOT can be read directly.
+	goroot := build.Default.GOROOT
+	goCmd := "go"
+	if goroot != "" {
+		pkg, err := build.Default.ImportDir(filepath.Join(goroot, "src", filepath.FromSlash(pkgPath)), 0)
+		if err == nil {
+			return pkg.Name
+		}
+		goCmd = filepath.Join(goroot, "bin", "go")
+	}
+	// Otherwise, ask the go command. go/build would run it too, but in a way
+	// that can download modules, or even a Go toolchain.
+	cmd := exec.Command(goCmd, "list", "-f", "{{.Name}}", "--", pkgPath)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPROXY=off", "GOTOOLCHAIN=local")
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// qualifiedName returns the way to refer to the named object in the package
+// with the given import path.
+func (p *Printer) qualifiedName(pkgPath, name string) string {
+	if ident := p.PackageIdentifier(pkgPath); ident != "" {
+		return ident + "." + name
+	}
+	return name
+}
+
+// Imports returns the packages referred to by the source that the Printer has
+// printed so far, as a map from import path to the identifier the source uses
+// for the package. The result can be used to write the import declarations of
+// a generated file.
+func (p *Printer) Imports() map[string]string {
+	var paths []string
+	p.mu.Lock()
+	for pkgPath := range p.used {
+		paths = append(paths, pkgPath)
+	}
+	p.mu.Unlock()
+	m := map[string]string{}
+	for _, pkgPath := range paths {
+		m[pkgPath] = p.PackageIdentifier(pkgPath)
+	}
+	return m
 }
 
 // PrintFuncs installs custom print functions for types. Each function should return
@@ -128,13 +280,59 @@ func processPrintFunc(pf interface{}) (argType reflect.Type, f printFunc, err er
 	return argType, f, nil
 }
 
+// PointerHelper tells the Printer to print pointers to values that aren't
+// composite literals, like numbers and strings, as calls to a generic function
+// with the given name, instead of as func literals. For example, with PointerHelper("ptr") a *int pointing to 7
+// prints as ptr(7), and a *int8 as ptr[int8](7). The function must be declared
+// in the package of the printed code, like
+//
+//   func ptr[T any](x T) *T { return &x }
+//
+// A File declares it if it is used. Generic functions require Go 1.18.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) PointerHelper(name string) *Printer {
+	p.ptrHelper = name
+	return p
+}
+
+// usePtrHelper records that the pointer helper was printed.
+func (p *Printer) usePtrHelper() {
+	p.mu.Lock()
+	p.ptrHelperUsed = true
+	p.mu.Unlock()
+}
+
+// pointerHelperDecl returns the declaration of the pointer helper.
+func (p *Printer) pointerHelperDecl() string {
+	return fmt.Sprintf("// %s returns a pointer to its argument.\nfunc %[1]s[T any](x T) *T { return &x }\n", p.ptrHelper)
+}
+
+// RequireSortedKeys makes printing fail when it reaches a map with more than one
+// key whose keys it can't sort, because the keys would be printed in a
+// different order each time. That includes maps whose key type can be sorted
+// but whose keys happen not to be, like interface keys holding channels, or
+// distinct pointers to equal values. The error names the key type and the location of
+// the map. Call LessFuncs to register a way to sort the keys.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) RequireSortedKeys() *Printer {
+	p.sortedKeys = true
+	return p
+}
+
 // LessFuncs associates types with functions that will be used to sort map keys
 // of that type.
 //
 // When rendering a map value as Go source, printsrc will sort the keys if it
 // can figure out how. By default it can sort any type whose underlying type is
-// numeric, string or bool. Maps with other key types will have their keys
-// printed in random order, complicating diffs. If a less function is registered
+// numeric, string or bool, and structs, arrays, pointers and interfaces made of
+// those: structs field by field, arrays element by element, pointers by the
+// values they point to, and interfaces by the name of their dynamic type and
+// then by value. Maps with other key types, like channels, will have their keys
+// printed in random order, complicating diffs. So will maps whose keys compare
+// equal that way without being equal, like interfaces holding channels or
+// distinct pointers to equal values. If a less function is registered
 // for a key type, however, then map keys of that type will be sorted.
 //
 // Each argument must be a function with the signature
@@ -183,38 +381,165 @@ func (p *Printer) getLessFunc(t reflect.Type) func(v1, v2 reflect.Value) bool {
 	if f, ok := p.lessFuncs[t]; ok {
 		return f
 	}
+	if !p.orderable(t, map[reflect.Type]bool{}) {
+		return nil
+	}
+	return func(v1, v2 reflect.Value) bool {
+		c, _ := p.compare(v1, v2, 0)
+		return c < 0
+	}
+}
+
+// keysOrdered reports whether keys, the sorted keys of a map of type t, are in
+// an order that doesn't depend on the map's iteration order.
+func (p *Printer) keysOrdered(t reflect.Type, keys []reflect.Value) bool {
+	if _, ok := p.lessFuncs[t.Key()]; ok || len(keys) < 2 {
+		return true
+	}
+	if !p.orderable(t.Key(), map[reflect.Type]bool{}) {
+		return false
+	}
+	// Keys that compare can't order compare equal, so after sorting they are
+	// next to each other.
+	for i := 1; i < len(keys); i++ {
+		if _, ok := p.compare(keys[i-1], keys[i], 0); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// orderable reports whether compare can order values of type t. The seen map
+// holds the types being checked, to stop at recursive types. Interface types
+// are orderable, but compare may not be able to order their dynamic values.
+func (p *Printer) orderable(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if _, ok := p.lessFuncs[t]; ok || seen[t] {
+		return true
+	}
+	seen[t] = true
 	switch t.Kind() {
-	case reflect.Bool:
-		return func(v1, v2 reflect.Value) bool {
-			return !v1.Bool() && v2.Bool()
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Array:
+		return p.orderable(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !p.orderable(t.Field(i).Type, seen) {
+				return false
+			}
 		}
-	case reflect.String:
-		return func(v1, v2 reflect.Value) bool {
-			return v1.String() < v2.String()
+		return true
+	default:
+		return false
+	}
+}
+
+// compare returns -1, 0 or 1 depending on whether v1 is less than, equal to or
+// greater than v2, which have the same type. Structs are compared field by
+// field, arrays element by element, and pointers by the values they point to,
+// with nil first. Interface values are compared first by the name of their
+// dynamic type, with nil first, and then by value.
+//
+// The second result is false if v1 and v2 may be different even though compare
+// returns 0, as for two channels, or two pointers to equal values.
+func (p *Printer) compare(v1, v2 reflect.Value, depth int) (int, bool) {
+	if depth > maxDepth {
+		return 0, false
+	}
+	depth++
+	if less, ok := p.lessFuncs[v1.Type()]; ok && v1.CanInterface() {
+		switch {
+		case less(v1, v2):
+			return -1, true
+		case less(v2, v1):
+			return 1, true
+		default:
+			return 0, true
 		}
+	}
+	switch v1.Kind() {
+	case reflect.Bool:
+		return compareOrdered(!v1.Bool() && v2.Bool(), v1.Bool() && !v2.Bool()), true
+	case reflect.String:
+		return compareOrdered(v1.String() < v2.String(), v1.String() > v2.String()), true
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return func(v1, v2 reflect.Value) bool {
-			return v1.Int() < v2.Int()
-		}
-
+		return compareOrdered(v1.Int() < v2.Int(), v1.Int() > v2.Int()), true
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return func(v1, v2 reflect.Value) bool {
-			return v1.Uint() < v2.Uint()
+		return compareOrdered(v1.Uint() < v2.Uint(), v1.Uint() > v2.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return compareOrdered(v1.Float() < v2.Float(), v1.Float() > v2.Float()), true
+	case reflect.Complex64, reflect.Complex128:
+		c1, c2 := v1.Complex(), v2.Complex()
+		if c := compareOrdered(real(c1) < real(c2), real(c1) > real(c2)); c != 0 {
+			return c, true
+		}
+		return compareOrdered(imag(c1) < imag(c2), imag(c1) > imag(c2)), true
+	case reflect.Ptr, reflect.Interface:
+		if v1.IsNil() || v2.IsNil() {
+			return compareOrdered(v1.IsNil() && !v2.IsNil(), !v1.IsNil() && v2.IsNil()), true
 		}
+		if v1.Kind() == reflect.Ptr && v1.Pointer() == v2.Pointer() {
+			return 0, true
+		}
+		e1, e2 := v1.Elem(), v2.Elem()
+		if t1, t2 := e1.Type(), e2.Type(); t1 != t2 {
+			if c := compareOrdered(t1.String() < t2.String(), t1.String() > t2.String()); c != 0 {
+				return c, true
+			}
+			// Different types can have the same name and package.
+			c := compareOrdered(t1.PkgPath() < t2.PkgPath(), t1.PkgPath() > t2.PkgPath())
+			return c, c != 0
+		}
+		c, ok := p.compare(e1, e2, depth)
+		if v1.Kind() == reflect.Ptr && c == 0 {
+			// Different pointers to equal values.
+			ok = false
+		}
+		return c, ok
+	case reflect.Array:
+		return p.compareSeq(v1.Len(), func(i int) (int, bool) { return p.compare(v1.Index(i), v2.Index(i), depth) })
+	case reflect.Struct:
+		return p.compareSeq(v1.NumField(), func(i int) (int, bool) { return p.compare(v1.Field(i), v2.Field(i), depth) })
+	case reflect.Chan, reflect.UnsafePointer:
+		return 0, v1.Pointer() == v2.Pointer()
+	default:
+		return 0, false
+	}
+}
 
-	case reflect.Float32, reflect.Float64:
-		return func(v1, v2 reflect.Value) bool {
-			return v1.Float() < v2.Float()
+// compareSeq compares two sequences of n values, given a function that compares
+// the i'th values. The first values that differ decide the result. If none do,
+// the sequences can only be ordered if all their values can.
+func (p *Printer) compareSeq(n int, compareAt func(int) (int, bool)) (int, bool) {
+	ordered := true
+	for i := 0; i < n; i++ {
+		c, ok := compareAt(i)
+		if c != 0 {
+			return c, true
 		}
+		ordered = ordered && ok
+	}
+	return 0, ordered
+}
 
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
 	default:
-		return nil
+		return 0
 	}
 }
 
 // Sprint returns a string that is a valid Go expression for value.
 // See the template example for how to use Sprint with text/template
-// to generate code.
+// to generate code, or use a File to generate a whole file.
 func (p *Printer) Sprint(value interface{}) (string, error) {
 	var buf bytes.Buffer
 	if err := p.Fprint(&buf, value); err != nil {
@@ -240,6 +565,68 @@ type state struct {
 	err      error
 	depth    int // recursive calls to print
 	tabDepth int // tabs from printSeq
+
+	path []pathElem // location of the value being printed
+	lit  bool       // the value just printed is a composite literal
+
+	decls    *declState // non-nil when printing declarations
+	declRoot bool       // the next value printed is the value of a declaration
+	declName string     // name of the declaration being printed
+}
+
+// A pathElem is one step along the path from the value passed to Fprint to the
+// value currently being printed.
+type pathElem struct {
+	kind  pathKind
+	name  string        // field name, for pathField
+	index int           // for pathIndex
+	key   reflect.Value // map key, for pathMapValue
+	typ   reflect.Type  // type being indexed, type of the map key, or dynamic type of the interface
+}
+
+type pathKind int
+
+const (
+	pathField    pathKind = iota // struct field
+	pathIndex                    // slice or array element
+	pathMapKey                   // map key
+	pathMapValue                 // map value
+	pathDeref                    // pointer indirection
+	pathAssert                   // dynamic value of an interface
+)
+
+// pathString describes the location of the value being printed, for error
+// messages. It looks like a Go expression starting from root.
+func (s *state) pathString(root string) string {
+	e := root
+	for _, pe := range s.path {
+		switch pe.kind {
+		case pathField:
+			e += "." + pe.name
+		case pathIndex:
+			e += fmt.Sprintf("[%d]", pe.index)
+		case pathMapKey:
+			e = "a key of " + e
+		case pathMapValue:
+			if pe.key.Kind() == reflect.String {
+				e += "[" + strconv.Quote(pe.key.String()) + "]"
+			} else {
+				e += fmt.Sprintf("[%v]", pe.key)
+			}
+		case pathDeref:
+			e = "(*" + e + ")"
+		case pathAssert:
+			e += ".(" + pe.typ.String() + ")"
+		}
+	}
+	return e
+}
+
+// printAt prints v, which is located at step e from the current value.
+func (s *state) printAt(e pathElem, v reflect.Value, imputedType reflect.Type, elide bool) {
+	s.path = append(s.path, e)
+	s.print(v, imputedType, elide)
+	s.path = s.path[:len(s.path)-1]
 }
 
 // Fail after this many recursive calls to state.print.
@@ -253,16 +640,29 @@ func (s *state) print(v reflect.Value, imputedType reflect.Type, elide bool) {
 		return
 	}
 	if s.depth > maxDepth {
-		s.err = errors.New("max recursion depth exceeded (probable circularity)")
+		s.err = errors.New("max recursion depth exceeded (probable circularity; use FprintDecls to print cyclic values)")
 		return
 	}
 	s.depth++
 	defer func() { s.depth-- }()
+	s.lit = false
 
 	if !v.IsValid() {
 		s.printString("nil")
 		return
 	}
+	if s.decls != nil {
+		if s.declRoot {
+			s.declRoot = false
+		} else if name, ok := s.decls.varFor(s, v); ok {
+			s.printString(name)
+			return
+		}
+	}
+	if name, ok := s.p.knownName(v); ok {
+		s.printString(name)
+		return
+	}
 	if cp := s.p.printFuncs[v.Type()]; cp != nil {
 		out, err := cp(v)
 		if err != nil {
@@ -270,6 +670,13 @@ func (s *state) print(v reflect.Value, imputedType reflect.Type, elide bool) {
 			return
 		}
 		s.printString(out)
+		s.lit = isCompositeLit(out)
+		return
+	}
+	if s.printReflectType(v) {
+		return
+	}
+	if s.printError(v) {
 		return
 	}
 
@@ -284,25 +691,65 @@ func (s *state) print(v reflect.Value, imputedType reflect.Type, elide bool) {
 	case reflect.Ptr:
 		s.printPtr(v, imputedType, elide)
 	case reflect.Interface:
-		s.print(v.Elem(), imputedType, elide)
+		if v.IsNil() {
+			s.print(v.Elem(), imputedType, elide)
+		} else {
+			s.printAt(pathElem{kind: pathAssert, typ: v.Elem().Type()}, v.Elem(), imputedType, elide)
+		}
 	case reflect.Slice, reflect.Array:
 		s.printSliceOrArray(v, imputedType, elide)
 	case reflect.Map:
 		s.printMap(v, imputedType, elide)
 	case reflect.Struct:
 		s.printStruct(v, imputedType, elide)
-	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+	case reflect.Func:
+		if s.printIfNil(v, imputedType) {
+			break
+		}
+		if s.p.useFuncNames {
+			s.printFuncName(v, imputedType)
+		} else {
+			s.err = fmt.Errorf("cannot print values of type %s as source (see UseFuncNames)", v.Type())
+		}
+	case reflect.Chan:
+		if !s.printIfNil(v, imputedType) {
+			s.printChan(v)
+		}
+	case reflect.UnsafePointer:
 		s.err = fmt.Errorf("cannot print values of type %s as source", v.Type())
 	default:
 		panic("bad kind")
 	}
 }
 
+// isCompositeLit reports whether src, the output of a custom print function,
+// is a composite literal.
+func isCompositeLit(src string) bool {
+	e, err := parser.ParseExpr(src)
+	if err != nil {
+		return false
+	}
+	_, ok := e.(*ast.CompositeLit)
+	return ok
+}
+
+// sprintLit is like sprint, but also reports whether v was printed as a
+// composite literal.
+func (s *state) sprintLit(v reflect.Value, imputedType reflect.Type, elide bool) (string, bool) {
+	s2 := *s
+	var buf bytes.Buffer
+	s2.w = &buf
+	s2.print(v, imputedType, elide)
+	s.err = s2.err
+	return buf.String(), s2.lit
+}
+
 func (s *state) sprint(v reflect.Value, imputedType reflect.Type, elide bool) string {
 	s2 := *s
 	var buf bytes.Buffer
 	s2.w = &buf
 	s2.print(v, imputedType, elide)
+	s.err = s2.err
 	return buf.String()
 }
 
@@ -333,7 +780,7 @@ func (s *state) printPrimitiveLiteral(v reflect.Value, imputedType reflect.Type)
 
 func (s *state) printFloat(v reflect.Value, imputedType reflect.Type) {
 	f := v.Float()
-	fs := specialFloatString(f)
+	fs := s.specialFloatString(f)
 	if fs == "" {
 		s.printPrimitiveLiteral(v, imputedType)
 		return
@@ -347,14 +794,14 @@ func (s *state) printFloat(v reflect.Value, imputedType reflect.Type) {
 	}
 }
 
-func specialFloatString(f float64) string {
+func (s *state) specialFloatString(f float64) string {
 	switch {
 	case math.IsNaN(f):
-		return "math.NaN()"
+		return s.p.qualifiedName("math", "NaN") + "()"
 	case math.IsInf(f, 1):
-		return "math.Inf(1)"
+		return s.p.qualifiedName("math", "Inf") + "(1)"
 	case math.IsInf(f, -1):
-		return "math.Inf(-1)"
+		return s.p.qualifiedName("math", "Inf") + "(-1)"
 	default:
 		return ""
 	}
@@ -362,8 +809,8 @@ func specialFloatString(f float64) string {
 
 func (s *state) printComplex(v reflect.Value, imputedType reflect.Type) {
 	c := v.Complex()
-	rs := specialFloatString(real(c))
-	is := specialFloatString(imag(c))
+	rs := s.specialFloatString(real(c))
+	is := s.specialFloatString(imag(c))
 	if rs == "" && is == "" {
 		s.printPrimitiveLiteral(v, imputedType)
 	} else {
@@ -383,14 +830,73 @@ func (s *state) printPtr(v reflect.Value, imputedType reflect.Type, elide bool)
 	if s.printIfNil(v, imputedType) {
 		return
 	}
+	// Unmarshal into the pointer itself, rather than taking the address of
+	// an unmarshaled value, unless the value has a print function.
+	if s.p.useMarshalers && s.p.printFuncs[elem.Type()] == nil && s.p.unprintableStruct(elem) && s.printMarshaled(v) {
+		return
+	}
 	if isPrimitive(elem.Kind()) {
-		s.printf("func() *%s { var x %[1]s = %s; return &x }()",
-			s.sprintType(elem.Type()), s.sprint(elem, elem.Type(), false))
-	} else if v.Type() == imputedType && elide {
-		s.print(elem, imputedType.Elem(), elide)
+		if h := s.p.ptrHelper; h != "" {
+			s.p.usePtrHelper()
+			if defaultType(elem) == elem.Type() {
+				s.printf("%s(%s)", h, s.sprint(elem, elem.Type(), false))
+			} else {
+				s.printf("%s[%s](%s)", h, s.sprintType(elem.Type()), s.sprint(elem, elem.Type(), false))
+			}
+		} else {
+			s.printf("func() *%s { var x %[1]s = %s; return &x }()",
+				s.sprintType(elem.Type()), s.sprint(elem, elem.Type(), false))
+		}
+		return
+	}
+	elided := v.Type() == imputedType && elide
+	s.path = append(s.path, pathElem{kind: pathDeref})
+	var (
+		src     string
+		lit     bool
+		nfixups int
+	)
+	if s.decls != nil {
+		nfixups = len(s.decls.fixups)
+	}
+	if elided {
+		src, lit = s.sprintLit(elem, imputedType.Elem(), elide)
 	} else {
-		s.printString("&")
-		s.print(elem, nil, false)
+		src, lit = s.sprintLit(elem, nil, false)
+	}
+	// The literal for the dynamic value of an interface has the wrong type.
+	if elem.Kind() == reflect.Interface {
+		lit = false
+	}
+	if elided && !lit && s.err == nil {
+		// The element will be written as an expression of its own, like the
+		// initializer of a variable, so its type can't be elided. Print it
+		// again, discarding the fixups from the first time.
+		if s.decls != nil {
+			s.decls.fixups = s.decls.fixups[:nfixups]
+		}
+		src, _ = s.sprintLit(elem, nil, false)
+	}
+	s.path = s.path[:len(s.path)-1]
+	switch {
+	case lit && elided && strings.HasPrefix(src, "{"):
+		// The element's type was elided, and so is the &.
+		s.printString(src)
+	case lit:
+		s.printString("&" + src)
+	case s.p.ptrHelper != "":
+		// Only a composite literal can have its address taken. Anything else,
+		// like a function call or another pointer, is passed to the helper.
+		// The type argument can be inferred unless src is untyped or has a
+		// different type from elem.
+		s.p.usePtrHelper()
+		if k := elem.Kind(); k == reflect.Interface || k == reflect.Func || src == "nil" {
+			s.printf("%s[%s](%s)", s.p.ptrHelper, s.sprintType(elem.Type()), src)
+		} else {
+			s.printf("%s(%s)", s.p.ptrHelper, src)
+		}
+	default:
+		s.printf("func() *%s { var x %[1]s = %s; return &x }()", s.sprintType(elem.Type()), src)
 	}
 }
 
@@ -408,8 +914,9 @@ func (s *state) printSliceOrArray(v reflect.Value, imputedType reflect.Type, eli
 
 	s.printString(ts)
 	s.printSeq(!oneLineValue(v), v.Len(), func(i int) {
-		s.print(v.Index(i), t.Elem(), true)
+		s.printAt(pathElem{kind: pathIndex, index: i, typ: t}, v.Index(i), t.Elem(), true)
 	})
+	s.lit = true
 }
 
 func (s *state) printMap(v reflect.Value, imputedType reflect.Type, elide bool) {
@@ -423,19 +930,131 @@ func (s *state) printMap(v reflect.Value, imputedType reflect.Type, elide bool)
 	} else {
 		ts = s.sprintType(t)
 	}
-	keys := v.MapKeys()
+	// Keys that aren't equal to themselves, like NaNs, can't be looked up, so
+	// collect the values along with the keys.
+	var keys, vals, nanKeys, nanVals []reflect.Value
+	iter := v.MapRange()
+	for iter.Next() {
+		if reflexive(iter.Key()) {
+			keys = append(keys, iter.Key())
+			vals = append(vals, iter.Value())
+		} else {
+			nanKeys = append(nanKeys, iter.Key())
+			nanVals = append(nanVals, iter.Value())
+		}
+	}
 	// Sort the keys if we can.
 	if less := s.p.getLessFunc(t.Key()); less != nil {
-		sort.Slice(keys, func(i, j int) bool {
-			return less(keys[i], keys[j])
+		sort.Sort(entrySorter{keys, vals, less})
+	}
+	if s.p.sortedKeys && !s.p.keysOrdered(t, keys) {
+		root := "value"
+		if s.decls != nil {
+			root = s.declName
+		}
+		s.err = fmt.Errorf("cannot sort keys of type %s in map at %s (see LessFuncs)", t.Key(), s.pathString(root))
+		return
+	}
+	printEntries := func() {
+		s.printSeq(!oneLineValue(v), len(keys), func(i int) {
+			s.printAt(pathElem{kind: pathMapKey}, keys[i], t.Key(), true)
+			s.printString(": ")
+			s.printAt(pathElem{kind: pathMapValue, key: keys[i], typ: t.Key()}, vals[i], t.Elem(), true)
 		})
 	}
-	s.printString(ts)
-	s.printSeq(!oneLineValue(v), len(keys), func(i int) {
-		s.print(keys[i], t.Key(), true)
-		s.printString(": ")
-		s.print(v.MapIndex(keys[i]), t.Elem(), true)
+	if len(nanKeys) == 0 {
+		s.printString(ts)
+		printEntries()
+		s.lit = true
+		return
+	}
+	s.printNaNMap(t, printEntries, nanKeys, nanVals)
+}
+
+// printNaNMap prints a map with keys that aren't equal to themselves as a func
+// literal. A map literal could hold them, since they aren't constants, but it
+// would look like it has duplicate keys. Instead, the entries with such keys are
+// added by assignments, ordered by their source.
+func (s *state) printNaNMap(t reflect.Type, printEntries func(), nanKeys, nanVals []reflect.Value) {
+	type assign struct{ key, val string }
+	var assigns []assign
+	for i := range nanKeys {
+		var a assign
+		s.path = append(s.path, pathElem{kind: pathMapKey})
+		a.key = s.sprint(nanKeys[i], t.Key(), false)
+		s.path[len(s.path)-1] = pathElem{kind: pathMapValue, key: nanKeys[i], typ: t.Key()}
+		a.val = s.sprint(nanVals[i], t.Elem(), false)
+		s.path = s.path[:len(s.path)-1]
+		assigns = append(assigns, a)
+	}
+	sort.SliceStable(assigns, func(i, j int) bool {
+		if assigns[i].key != assigns[j].key {
+			return assigns[i].key < assigns[j].key
+		}
+		return assigns[i].val < assigns[j].val
 	})
+	ts := s.sprintType(t)
+	newline := func() {
+		s.printString("\n")
+		for i := 0; i < s.tabDepth; i++ {
+			s.printString("\t")
+		}
+	}
+	s.printf("func() %s {", ts)
+	s.tabDepth++
+	newline()
+	s.printf("m := %s", ts)
+	printEntries()
+	for _, a := range assigns {
+		newline()
+		s.printf("m[%s] = %s", a.key, a.val)
+	}
+	newline()
+	s.printString("return m")
+	s.tabDepth--
+	newline()
+	s.printString("}()")
+	s.lit = false
+}
+
+// entrySorter sorts map keys and their values by key.
+type entrySorter struct {
+	keys, vals []reflect.Value
+	less       func(v1, v2 reflect.Value) bool
+}
+
+func (e entrySorter) Len() int           { return len(e.keys) }
+func (e entrySorter) Less(i, j int) bool { return e.less(e.keys[i], e.keys[j]) }
+func (e entrySorter) Swap(i, j int) {
+	e.keys[i], e.keys[j] = e.keys[j], e.keys[i]
+	e.vals[i], e.vals[j] = e.vals[j], e.vals[i]
+}
+
+// reflexive reports whether v is equal to itself. Only values that contain a
+// floating-point NaN are not.
+func reflexive(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return !math.IsNaN(v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return !math.IsNaN(real(c)) && !math.IsNaN(imag(c))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !reflexive(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !reflexive(v.Field(i)) {
+				return false
+			}
+		}
+	case reflect.Interface:
+		return v.IsNil() || reflexive(v.Elem())
+	}
+	return true
 }
 
 func (s *state) printStruct(v reflect.Value, imputedType reflect.Type, elide bool) {
@@ -445,7 +1064,7 @@ func (s *state) printStruct(v reflect.Value, imputedType reflect.Type, elide boo
 		multiline = false
 	)
 	for i := 0; i < t.NumField(); i++ {
-		if (t.PkgPath() == s.p.pkgPath || isExported(t.Field(i))) && !v.Field(i).IsZero() {
+		if s.p.printableField(t, i) && !v.Field(i).IsZero() {
 			inds = append(inds, i)
 			if !oneLineType(t.Field(i).Type) {
 				multiline = true
@@ -453,6 +1072,9 @@ func (s *state) printStruct(v reflect.Value, imputedType reflect.Type, elide boo
 		}
 	}
 	if len(inds) == 0 && !v.IsZero() {
+		if s.p.useMarshalers && s.printMarshaled(v) {
+			return
+		}
 		s.err = fmt.Errorf("non-zero %s struct has no printable fields; call Printer.RegisterPrinter(%[1]s{}, ...)", t)
 		return
 	}
@@ -465,8 +1087,30 @@ func (s *state) printStruct(v reflect.Value, imputedType reflect.Type, elide boo
 	s.printSeq(multiline, len(inds), func(i int) {
 		ind := inds[i]
 		s.printf("%s: ", t.Field(ind).Name)
-		s.print(v.Field(ind), t.Field(ind).Type, false)
+		s.printAt(pathElem{kind: pathField, name: t.Field(ind).Name}, v.Field(ind), t.Field(ind).Type, false)
 	})
+	s.lit = true
+}
+
+// unprintableStruct reports whether v is a struct that can't be printed as a
+// struct literal, because it is not zero but all its printable fields are.
+func (p *Printer) unprintableStruct(v reflect.Value) bool {
+	if v.Kind() != reflect.Struct || v.IsZero() {
+		return false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if p.printableField(v.Type(), i) && !v.Field(i).IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// printableField reports whether the i'th field of the struct type t can be
+// set in a struct literal in the Printer's package.
+func (p *Printer) printableField(t reflect.Type, i int) bool {
+	f := t.Field(i)
+	return isExported(f) || f.PkgPath == p.pkgPath
 }
 
 func isExported(f reflect.StructField) bool {
@@ -513,11 +1157,12 @@ func (s *state) sprintType(t reflect.Type) string {
 		if pkgPath == "" {
 			return t.String()
 		}
-		ident := s.p.PackageIdentifier(pkgPath)
-		if ident == "" {
-			return t.Name()
+		name := t.Name()
+		if i := strings.IndexByte(name, '['); i >= 0 {
+			// An instantiated generic type.
+			name = name[:i] + s.p.qualifyTypeArgs(name[i:])
 		}
-		return ident + "." + t.Name()
+		return s.p.qualifiedName(pkgPath, name)
 	}
 	switch t.Kind() {
 	case reflect.Ptr:
@@ -528,28 +1173,210 @@ func (s *state) sprintType(t reflect.Type) string {
 		return fmt.Sprintf("[%d]", t.Len()) + s.sprintType(t.Elem())
 	case reflect.Map:
 		return "map[" + s.sprintType(t.Key()) + "]" + s.sprintType(t.Elem())
-	case reflect.Interface:
-		if t.NumMethod() == 0 {
-			return "interface{}"
+	case reflect.Struct:
+		return s.sprintStructType(t)
+	case reflect.Func:
+		return "func" + s.sprintSignature(t)
+	case reflect.Chan:
+		switch t.ChanDir() {
+		case reflect.RecvDir:
+			return "<-chan " + s.sprintType(t.Elem())
+		case reflect.SendDir:
+			return "chan<- " + s.sprintType(t.Elem())
+		default:
+			e := t.Elem()
+			if e.Name() == "" && e.Kind() == reflect.Chan && e.ChanDir() == reflect.RecvDir {
+				// "chan <-chan T" would mean "chan<- chan T".
+				return "chan (" + s.sprintType(e) + ")"
+			}
+			return "chan " + s.sprintType(e)
 		}
+	case reflect.Interface:
+		return s.sprintInterfaceType(t)
 	}
 	s.err = fmt.Errorf("can't handle unnamed type %s", t)
 	return ""
 }
 
+var tReflectType = reflect.TypeOf((*reflect.Type)(nil)).Elem()
+
+// printReflectType prints v if it is a reflect.Type, as an expression that
+// evaluates to the same type. It reports whether it printed v.
+func (s *state) printReflectType(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface || !v.Type().Implements(tReflectType) || !v.CanInterface() {
+		return false
+	}
+	t := v.Interface().(reflect.Type)
+	if s.p.goAtLeast(22) {
+		ts := s.sprintType(t)
+		if s.err == nil {
+			s.printf("%s[%s]()", s.p.qualifiedName("reflect", "TypeFor"), ts)
+		}
+		return true
+	}
+	// Going through a pointer works for every type, including interfaces.
+	ts := s.sprintConversionType(reflect.PtrTo(t))
+	if s.err != nil {
+		return true
+	}
+	s.printf("%s(%s(nil)).Elem()", s.p.qualifiedName("reflect", "TypeOf"), ts)
+	return true
+}
+
+// sprintConversionType returns the type t as it should appear in a conversion.
+// Some unnamed types must be parenthesized to avoid ambiguity.
+func (s *state) sprintConversionType(t reflect.Type) string {
+	ts := s.sprintType(t)
+	if t.Name() == "" {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Func, reflect.Chan:
+			return "(" + ts + ")"
+		}
+	}
+	return ts
+}
+
+// sprintSignature returns the parameters and results of the function type t,
+// as they appear after "func" in a function type or method specification.
+func (s *state) sprintSignature(t reflect.Type) string {
+	var ins, outs []string
+	for i := 0; i < t.NumIn(); i++ {
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			ins = append(ins, "..."+s.sprintType(t.In(i).Elem()))
+		} else {
+			ins = append(ins, s.sprintType(t.In(i)))
+		}
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		outs = append(outs, s.sprintType(t.Out(i)))
+	}
+	sig := "(" + strings.Join(ins, ", ") + ")"
+	switch len(outs) {
+	case 0:
+		return sig
+	case 1:
+		return sig + " " + outs[0]
+	default:
+		return sig + " (" + strings.Join(outs, ", ") + ")"
+	}
+}
+
+// sprintInterfaceType returns an interface type literal for the unnamed
+// interface type t. Embedded interfaces appear as their methods.
+func (s *state) sprintInterfaceType(t reflect.Type) string {
+	if t.NumMethod() == 0 {
+		if s.p.goAtLeast(18) {
+			return "any"
+		}
+		return "interface{}"
+	}
+	var methods []string
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if m.PkgPath != "" && m.PkgPath != s.p.pkgPath {
+			s.err = fmt.Errorf("can't write type %s: unexported method %s is from package %s", t, m.Name, m.PkgPath)
+			return ""
+		}
+		methods = append(methods, m.Name+s.sprintSignature(m.Type))
+	}
+	return "interface{ " + strings.Join(methods, "; ") + " }"
+}
+
+// sprintStructType returns a struct type literal for the unnamed struct type t.
+func (s *state) sprintStructType(t reflect.Type) string {
+	if t.NumField() == 0 {
+		return "struct{}"
+	}
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !isExported(f) && f.PkgPath != s.p.pkgPath {
+			// The type literal would declare a field in the wrong package,
+			// so it would denote a different type.
+			s.err = fmt.Errorf("can't write type %s: unexported field %s is from package %s", t, f.Name, f.PkgPath)
+			return ""
+		}
+		fs := s.sprintType(f.Type)
+		if !f.Anonymous {
+			fs = f.Name + " " + fs
+		}
+		if f.Tag != "" {
+			if strconv.CanBackquote(string(f.Tag)) {
+				fs += " `" + string(f.Tag) + "`"
+			} else {
+				fs += " " + strconv.Quote(string(f.Tag))
+			}
+		}
+		fields = append(fields, fs)
+	}
+	return "struct{ " + strings.Join(fields, "; ") + " }"
+}
+
+// qualifyTypeArgs rewrites the type argument list of an instantiated generic
+// type name, as returned by reflect.Type.Name, into Go source. The reflect
+// package writes named types in type arguments with their full import path, as
+// in "Pair[int,example.com/geo.Point]". qualifyTypeArgs replaces each import
+// path with the package's identifier, and puts a space after each comma that
+// separates type arguments.
+func (p *Printer) qualifyTypeArgs(args string) string {
+	var b strings.Builder
+	isSep := func(c byte) bool { return strings.IndexByte("[]*(){}<,; \"", c) >= 0 }
+	for i := 0; i < len(args); {
+		c := args[i]
+		switch {
+		case c == '"':
+			// A struct tag. Copy it, including escaped characters.
+			j := i + 1
+			for j < len(args) && args[j] != '"' {
+				if args[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(args) {
+				j++
+			}
+			b.WriteString(args[i:j])
+			i = j
+		case c == ',':
+			b.WriteByte(c)
+			if i+1 < len(args) && args[i+1] != ' ' {
+				b.WriteByte(' ')
+			}
+			i++
+		case isSep(c):
+			b.WriteByte(c)
+			i++
+		default:
+			// A word: a predeclared or qualified type name, a keyword, a
+			// field name or an array length.
+			j := i
+			for j < len(args) && !isSep(args[j]) {
+				j++
+			}
+			word := args[i:j]
+			prefix := ""
+			if strings.HasPrefix(word, "...") {
+				prefix, word = "...", word[3:]
+			}
+			if k := strings.LastIndexByte(word, '.'); k >= 0 {
+				word = p.qualifiedName(word[:k], word[k+1:])
+			}
+			b.WriteString(prefix + word)
+			i = j
+		}
+	}
+	return b.String()
+}
+
 func (s *state) printIfNil(v reflect.Value, imputedType reflect.Type) bool {
 	switch v.Kind() {
-	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
 		if v.IsNil() {
 			if v.Type() == imputedType {
 				s.printString("nil")
 			} else {
-				ts := s.sprintType(v.Type())
-				if ts[0] == '*' {
-					s.printf("(%s)(nil)", ts)
-				} else {
-					s.printf("%s(nil)", ts)
-				}
+				s.printf("%s(nil)", s.sprintConversionType(v.Type()))
 			}
 			return true
 		}
@@ -598,8 +1425,9 @@ func oneLineValue(v reflect.Value) bool {
 		if v.Len() == 0 {
 			return true
 		}
-		k0 := v.MapKeys()[0]
-		return (v.Len() == 1 && oneLineValue(k0) && oneLineValue(v.MapIndex(k0))) ||
+		iter := v.MapRange()
+		iter.Next()
+		return (v.Len() == 1 && oneLineValue(iter.Key()) && oneLineValue(iter.Value())) ||
 			(v.Len() <= 5 && oneLineType(v.Type().Key()) && oneLineType(v.Type().Elem()))
 	default:
 		return false
@@ -0,0 +1,85 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.18
+// +build go1.18
+
+package printsrc
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+type Key struct{ K int }
+
+type Pair[K, V any] struct {
+	First  K
+	Second V
+}
+
+type Set[T comparable] map[T]bool
+
+func TestPrintGeneric(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").Import("text/template", "ttemp")
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{Pair[int, Key]{1, Key{2}}, "Pair[int, Key]{First: 1, Second: Key{K: 2}}"},
+		{Set[time.Duration]{}, "Set[time.Duration]{}"},
+		{Set[Key]{{1}: true}, "Set[Key]{{K: 1}: true}"},
+		{Pair[[]*template.Template, Set[Pair[int, string]]]{}, "Pair[[]*ttemp.Template, Set[Pair[int, string]]]{}"},
+		{
+			Pair[struct {
+				X int `json:"x.y"`
+			}, time.Month]{},
+			`Pair[struct { X int "json:\"x.y\"" }, time.Month]{}`,
+		},
+		{[]Pair[int, bool](nil), "[]Pair[int, bool](nil)"},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+		if got != test.want {
+			t.Errorf("%#v (%[1]T):\ngot\n\t%s\nwant\n\t%s", test.in, got, test.want)
+		}
+	}
+	want := map[string]string{"text/template": "ttemp", "time": "time"}
+	got := p.Imports()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imports: got %v, want %v", got, want)
+	}
+}
+
+func TestPrintNetip(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc")
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{netip.MustParseAddr("10.0.0.1"), `netip.MustParseAddr("10.0.0.1")`},
+		{netip.MustParseAddr("fe80::1%eth0"), `netip.MustParseAddr("fe80::1%eth0")`},
+		{netip.Addr{}, "netip.Addr{}"},
+		{netip.MustParsePrefix("10.0.0.0/8"), `netip.MustParsePrefix("10.0.0.0/8")`},
+		{netip.MustParseAddrPort("[::1]:80"), `netip.MustParseAddrPort("[::1]:80")`},
+		{netip.Prefix{}, "netip.Prefix{}"},
+		{
+			[]netip.Addr{netip.MustParseAddr("::1")},
+			"[]netip.Addr{\n\tnetip.MustParseAddr(\"::1\"),\n}",
+		},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%#v (%[1]T):\ngot\n\t%s\nwant\n\t%s", test.in, got, test.want)
+		}
+	}
+}
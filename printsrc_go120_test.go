@@ -0,0 +1,44 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.20
+// +build go1.20
+
+package printsrc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPrintMultipleWrappedErrors(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").GoVersion("1.20")
+	for _, test := range []struct {
+		in   error
+		want string
+	}{
+		{fmt.Errorf("%w%w", io.EOF, io.EOF), `fmt.Errorf("%w%w", errors.New("EOF"), errors.New("EOF"))`},
+		{
+			fmt.Errorf("a: %w, b: %w", errors.New("x"), fmt.Errorf("y: %w", errors.New("z"))),
+			`fmt.Errorf("a: %w, b: %w", errors.New("x"), fmt.Errorf("y: %w", errors.New("z")))`,
+		},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%v: got %s, want %s", test.in, got, test.want)
+		}
+	}
+
+	// By default, and before Go 1.20, they can't be printed.
+	for _, p := range []*Printer{NewPrinter("x").GoVersion("1.19"), NewPrinter("x")} {
+		_, err := p.Sprint(fmt.Errorf("%w%w", io.EOF, io.EOF))
+		if err == nil || !strings.Contains(err.Error(), "requires Go 1.20") {
+			t.Errorf("got %v, looking for %q", err, "requires Go 1.20")
+		}
+	}
+}
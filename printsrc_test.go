@@ -6,13 +6,26 @@
 package printsrc
 
 import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
 	"math"
 	"math/big"
 	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"text/template"
 	"time"
+	_ "time/tzdata"
 )
 
 type T struct {
@@ -134,6 +147,34 @@ func TestPrint(t *testing.T) {
 		},
 		{[]*[]int{{1}}, "[]*[]int{{1},}"},
 
+		// funcs, channels and interfaces
+		{[]error(nil), "[]error(nil)"},
+		{map[string]fmt.Stringer{}, "map[string]fmt.Stringer{}"},
+		{(func(int) bool)(nil), "(func(int) bool)(nil)"},
+		{(func(string, ...int) (bool, error))(nil), "(func(string, ...int) (bool, error))(nil)"},
+		{[]func(){nil}, "[]func(){nil}"},
+		{[]interface{}{(func())(nil)}, "[]interface{}{(func())(nil),}"},
+		{(chan int)(nil), "(chan int)(nil)"},
+		{(<-chan int)(nil), "(<-chan int)(nil)"},
+		{(chan<- []int)(nil), "(chan<- []int)(nil)"},
+		{(chan (<-chan int))(nil), "(chan (<-chan int))(nil)"},
+		{(chan<- chan int)(nil), "(chan<- chan int)(nil)"},
+		{[]interface{ String() string }{nil}, "[]interface{ String() string }{nil}"},
+		{
+			map[string]interface {
+				fmt.Stringer
+				Len() int
+			}{},
+			"map[string]interface{ Len() int; String() string }{}",
+		},
+		{
+			struct {
+				F func(int) bool
+				X int
+			}{X: 1},
+			"struct{ F func(int) bool; X int }{X: 1}",
+		},
+
 		// slices and arrays
 		{[]int(nil), "[]int(nil)"},
 		{[]int{}, "[]int{}"},
@@ -177,6 +218,24 @@ func TestPrint(t *testing.T) {
 		{[]Nested{{B: 1}}, "[]Nested{{B: 1}}"},
 		{[]*Nested{{B: 1}, nil}, "[]*Nested{{B: 1},nil,}"},
 		{Unexp{1, 2}, "Unexp{E: 1.0, u: 2.0}"},
+		{struct{}{}, "struct{}{}"},
+		{struct{ X int }{3}, "struct{ X int }{X: 3}"},
+		{
+			[]struct {
+				Name string `json:"name"`
+				Port int
+				u    bool
+			}{{"a", 1, true}},
+			"[]struct{ Name string `json:\"name\"`; Port int; u bool }{{Name: \"a\",Port: 1,u: true,},}",
+		},
+		{struct{ Nested }{Nested{1}}, "struct{ Nested }{Nested: Nested{B: 1}}"},
+		{
+			struct {
+				*Nested
+				T time.Time "x:\"a`b\""
+			}{},
+			"struct{ *Nested; T time.Time \"x:\\\"a`b\\\"\" }{}",
+		},
 
 		{map[Nested]Nested{{B: 1}: {B: 2}}, "map[Nested]Nested{{B: 1}: {B: 2}}"},
 		{[]Float{Float(math.NaN())}, "[]Float{Float(math.NaN())}"},
@@ -205,6 +264,127 @@ func TestPrint(t *testing.T) {
 		{[2]*Point{{1.5, -3.5}, {}}, "[2]*Point{{x: 1.5, y: -3.5},{},}"},
 		{[2]PPoint{{1.5, -3.5}, {}}, "[2]PPoint{{x: 1.5, y: -3.5},{},}"},
 
+		// math/big
+		{big.NewInt(-7), "big.NewInt(-7)"},
+		{*big.NewInt(8), "*big.NewInt(8)"},
+		{(*big.Int)(nil), "(*big.Int)(nil)"},
+		{
+			new(big.Int).Lsh(big.NewInt(1), 100),
+			`func() *big.Int { x, _ := new(big.Int).SetString("1267650600228229401496703205376", 10); return x }()`,
+		},
+		{big.NewRat(-3, 6), "big.NewRat(-1, 2)"},
+		{[]big.Rat{*big.NewRat(1, 3)}, "[]big.Rat{*big.NewRat(1, 3),}"},
+		{
+			new(big.Rat).SetFrac(new(big.Int).Lsh(big.NewInt(1), 70), big.NewInt(3)),
+			`func() *big.Rat { x, _ := new(big.Rat).SetString("1180591620717411303424/3"); return x }()`,
+		},
+		{big.Float{}, "big.Float{}"},
+		{new(big.Float), "new(big.Float)"},
+		{new(big.Float).Neg(new(big.Float)), "new(big.Float).Neg(new(big.Float))"},
+		{*new(big.Float).Neg(new(big.Float)), "*new(big.Float).Neg(new(big.Float))"},
+		{new(big.Float).SetMode(big.AwayFromZero), "new(big.Float).SetMode(big.AwayFromZero)"},
+		{
+			big.NewFloat(1.5),
+			`func() *big.Float { x, _, _ := big.ParseFloat("1.5", 10, 53, big.ToNearestEven); return x }()`,
+		},
+		{
+			*new(big.Float).SetPrec(200).SetMode(big.ToZero).SetInt64(-3),
+			`*func() *big.Float { x, _, _ := big.ParseFloat("-3", 10, 200, big.ToNearestEven); return x.SetMode(big.ToZero) }()`,
+		},
+		{
+			new(big.Float).SetInf(true),
+			`func() *big.Float { x, _, _ := big.ParseFloat("-Inf", 10, 0, big.ToNearestEven); return x }()`,
+		},
+
+		// net and net/url
+		{net.IPv4(10, 0, 0, 1), `net.ParseIP("10.0.0.1")`},
+		{net.IPv4(10, 0, 0, 1).To4(), `net.ParseIP("10.0.0.1").To4()`},
+		{net.ParseIP("2001:db8::1"), `net.ParseIP("2001:db8::1")`},
+		{net.IP(nil), "net.IP(nil)"},
+		{net.IP{1, 2}, "net.IP{0x1, 0x2}"},
+		{net.CIDRMask(24, 32), "net.CIDRMask(24, 32)"},
+		{net.IPv4Mask(255, 0, 255, 0), "net.IPv4Mask(255, 0, 255, 0)"},
+		{
+			&net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+			`&net.IPNet{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(8, 32)}`,
+		},
+		{
+			&url.URL{Scheme: "https", Host: "example.com", Path: "/a", RawQuery: "b=c"},
+			`func() *url.URL { u, _ := url.Parse("https://example.com/a?b=c"); return u }()`,
+		},
+		{
+			url.URL{Scheme: "http", User: url.UserPassword("u", "p"), Host: "h"},
+			`*func() *url.URL { u, _ := url.Parse("http://u:p@h"); return u }()`,
+		},
+
+		// NaN map keys
+		{
+			map[float64]string{math.NaN(): "y", 2: "b", math.NaN(): "x", 1: "a"},
+			`func() map[float64]string {m := map[float64]string{1.0: "a",2.0: "b",}m[math.NaN()] = "x"m[math.NaN()] = "y"return m}()`,
+		},
+		{
+			map[float32]Point{float32(math.NaN()): {1, 2}},
+			`func() map[float32]Point {m := map[float32]Point{}m[float32(math.NaN())] = Point{x: 1, y: 2}return m}()`,
+		},
+		{
+			[]map[interface{}]bool{{math.NaN(): true}},
+			`[]map[interface{}]bool{func() map[interface{}]bool {m := map[interface{}]bool{}m[math.NaN()] = truereturn m}(),}`,
+		},
+
+		// channels
+		{make(chan int), "make(chan int)"},
+		{make(chan string, 3), "make(chan string, 3)"},
+		{func() chan int { c := make(chan int, 2); close(c); return c }(), "make(chan int, 2)"},
+		{(<-chan int)(make(chan int, 2)), "make(<-chan int, 2)"},
+		{(chan<- int)(nil), "(chan<- int)(nil)"},
+		{struct{ c <-chan int }{make(chan int, 1)}, "struct{ c <-chan int }{c: make(<-chan int, 1)}"},
+		{
+			struct{ C chan<- bool }{C: make(chan bool)},
+			"struct{ C chan<- bool }{C: make(chan<- bool)}",
+		},
+
+		// errors
+		{errors.New("bad"), `errors.New("bad")`},
+		{fmt.Errorf("no %s", "good"), `errors.New("no good")`},
+		{fmt.Errorf("100%%: %w", errors.New("bad")), `fmt.Errorf("100%%: %w", errors.New("bad"))`},
+		{[]error{errors.New("e"), nil}, `[]error{errors.New("e"),nil,}`},
+		{(*fs.PathError)(nil), "(*fs.PathError)(nil)"},
+
+		// reflect.Type
+		{reflect.TypeOf(0), "reflect.TypeOf((*int)(nil)).Elem()"},
+		{reflect.TypeOf(time.Time{}), "reflect.TypeOf((*time.Time)(nil)).Elem()"},
+		{tError, "reflect.TypeOf((*error)(nil)).Elem()"},
+		{reflect.TypeOf(func(int) {}), "reflect.TypeOf((*func(int))(nil)).Elem()"},
+		{
+			map[string]reflect.Type{"p": reflect.TypeOf(&node{})},
+			`map[string]reflect.Type{"p": reflect.TypeOf((**node)(nil)).Elem(),}`,
+		},
+		{[]reflect.Type{nil}, "[]reflect.Type{nil}"},
+
+		// regexp
+		{regexp.MustCompile("a|b"), `regexp.MustCompile("a|b")`},
+		{regexp.MustCompile(`\d+\.`), "regexp.MustCompile(`\\d+\\.`)"},
+		{regexp.MustCompilePOSIX("a+"), `regexp.MustCompilePOSIX("a+")`},
+		{
+			func() *regexp.Regexp { re := regexp.MustCompile(`\d*`); re.Longest(); return re }(),
+			"func() *regexp.Regexp { re := regexp.MustCompile(`\\d*`); re.Longest(); return re }()",
+		},
+		{(*regexp.Regexp)(nil), "(*regexp.Regexp)(nil)"},
+
+		// time.Duration
+		{90 * time.Second, "90 * time.Second"},
+		{time.Hour, "time.Hour"},
+		{-time.Minute, "-time.Minute"},
+		{2 * time.Hour, "2 * time.Hour"},
+		{time.Hour + 30*time.Minute, "1*time.Hour + 30*time.Minute"},
+		{-(time.Hour + 1), "-(1*time.Hour + 1*time.Nanosecond)"},
+		{1500 * time.Millisecond, "1500 * time.Millisecond"},
+		{59*time.Minute + 1500*time.Millisecond, "59*time.Minute + 1*time.Second + 500*time.Millisecond"},
+		{time.Duration(0), "time.Duration(0)"},
+		{time.Duration(math.MinInt64), "time.Duration(-9223372036854775808)"},
+		{[]interface{}{time.Second}, "[]interface{}{time.Second,}"},
+		{map[string]time.Duration{"a": 0}, `map[string]time.Duration{"a": time.Duration(0)}`},
+
 		// time.Time
 		{
 			time.Date(2008, 4, 23, 9, 56, 23, 29, time.Local),
@@ -226,6 +406,26 @@ func TestPrint(t *testing.T) {
 	}
 }
 
+func TestConcurrentPrint(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").ResolvePackageNames("").PointerHelper("ptr")
+	i := 5
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := p.Sprint([]interface{}{time.Second, &i})
+			if err != nil {
+				t.Error(err)
+			} else if want := "[]interface{}{time.Second,ptr(5),}"; strings.NewReplacer("\n", "", "\t", "").Replace(got) != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+			p.Imports()
+		}()
+	}
+	wg.Wait()
+}
+
 func TestPrintVerticalWhitespace(t *testing.T) {
 	p := NewPrinter("github.com/jba/printsrc")
 	for _, test := range []struct {
@@ -274,12 +474,16 @@ func TestPrintErrors(t *testing.T) {
 		in   interface{}
 		want string
 	}{
-		{struct{ X int }{3}, "unnamed type"},
 		{func() {}, "cannot print"},
-		{make(chan int), "cannot print"},
+		{func() chan int { c := make(chan int, 1); c <- 1; return c }(), "holds 1 buffered elements"},
 		{n, "depth exceeded"},
-		{time.Date(2008, 4, 23, 9, 56, 23, 29, time.FixedZone("foo", 17)), "location"},
-		{big.NewInt(1), "RegisterPrinter"},
+		{strings.NewReader("x"), "RegisterPrinter"},
+		{&url.URL{Path: "a b", RawPath: "a%20b"}, "url.Parse does not reproduce"},
+		{template.New("t"), "has not been parsed"},
+		{
+			template.Must(template.New("t").Funcs(template.FuncMap{"f": strings.ToUpper}).Parse("{{f .}}")),
+			`function "f" not defined`,
+		},
 	} {
 		_, err := p.Sprint(test.in)
 		if err == nil {
@@ -288,6 +492,150 @@ func TestPrintErrors(t *testing.T) {
 			t.Errorf("%#v: got %q, looking for %q", test.in, err, test.want)
 		}
 	}
+
+	// A struct type literal with an unexported field can only be written in
+	// the field's package.
+	in := []struct{ X, y int }{{1, 2}}
+	_, err := NewPrinter("github.com/jba/printsrc/other").Sprint(in)
+	if err == nil || !strings.Contains(err.Error(), "unexported field y") {
+		t.Errorf("%#v: got %v, looking for %q", in, err, "unexported field y")
+	}
+}
+
+func TestPrintTemplates(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc")
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{
+			template.Must(template.New("t").Parse("Hello, {{.Name}}!")),
+			`template.Must(template.New("t").Parse("Hello, {{.Name}}!"))`,
+		},
+		{
+			template.Must(template.New("t").Delims("<<", ">>").Parse(`<<template "u" .>>
+<<- define "u">><<if .>>yes<<end>><<end>>`)),
+			`template.Must(template.New("t").Delims("<<", ">>").Parse("<<template \"u\" .>><<define \"u\">><<if .>>yes<<end>><<end>>"))`,
+		},
+		{
+			htmltemplate.Must(htmltemplate.New("h").Parse("<p>{{.}}</p>")),
+			`template.Must(template.New("h").Parse("<p>{{.}}</p>"))`,
+		},
+		{(*template.Template)(nil), "(*template.Template)(nil)"},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("got\n%s\nwant\n%s", got, test.want)
+		}
+	}
+}
+
+var (
+	defaultNesting = nesting{A: 1}
+	rootNode       = &node{v: 1}
+	defaultMap     = MyMap{"a": 1}
+)
+
+func TestKnownVars(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").
+		KnownVar("example.com/config", "Default", &defaultNesting).
+		KnownVar("example.com/config", "Root", &rootNode).
+		KnownVar("example.com/config", "Map", &defaultMap).
+		KnownValue("example.com/config", "Origin", Point{}).
+		Sentinel("io", "EOF", io.EOF)
+	in := []interface{}{
+		&defaultNesting, defaultNesting, rootNode, &node{v: 1}, defaultMap, Point{}, Point{1, 2},
+		io.EOF, errors.New("EOF"), fmt.Errorf("reading: %w", io.EOF),
+	}
+	got, err := p.Sprint(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+	want := `[]interface{}{` +
+		`&config.Default,nesting{A: 1},config.Root,&node{v: 1},config.Map,config.Origin,Point{x: 1, y: 2},` +
+		`io.EOF,errors.New("EOF"),fmt.Errorf("reading: %w", io.EOF),}`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// Known variables are not written as shared variables.
+	got, err = p.SprintDecls("nodes", []*node{rootNode, rootNode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "var nodes = []*node{\n\tconfig.Root,\n\tconfig.Root,\n}\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+type strFunc func(string) string
+
+func double(x int) int { return 2 * x }
+
+func TestUseFuncNames(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").UseFuncNames()
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{strings.ToUpper, "strings.ToUpper"},
+		{double, "double"},
+		{strFunc(strings.ToLower), "strFunc(strings.ToLower)"},
+		{[]strFunc{strings.TrimSpace}, "[]strFunc{strings.TrimSpace,}"},
+		{map[string]interface{}{"f": strFunc(strings.TrimSpace)}, `map[string]interface{}{"f": strFunc(strings.TrimSpace),}`},
+		{(func())(nil), "(func())(nil)"},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+		if got != test.want {
+			t.Errorf("%T: got %s, want %s", test.in, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{func() {}, "not a top-level function"},
+		{time.Time{}.String, "not a top-level function"},
+		{time.Time.String, "not a top-level function"},
+	} {
+		_, err := p.Sprint(test.in)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%T: got %v, looking for %q", test.in, err, test.want)
+		}
+	}
+	_, err := NewPrinter("github.com/jba/printsrc/other").UseFuncNames().Sprint(double)
+	if err == nil || !strings.Contains(err.Error(), "unexported function") {
+		t.Errorf("got %v, looking for %q", err, "unexported function")
+	}
+}
+
+func TestSplitFuncName(t *testing.T) {
+	for _, test := range []struct {
+		in            string
+		pkgPath, name string
+	}{
+		{"strings.ToUpper", "strings", "ToUpper"},
+		{"github.com/jba/printsrc.NewPrinter", "github.com/jba/printsrc", "NewPrinter"},
+		{"gopkg.in/yaml%2ev3.Marshal", "gopkg.in/yaml.v3", "Marshal"},
+	} {
+		pkgPath, name, err := splitFuncName(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkgPath != test.pkgPath || name != test.name {
+			t.Errorf("%s: got (%q, %q), want (%q, %q)", test.in, pkgPath, name, test.pkgPath, test.name)
+		}
+	}
 }
 
 func TestRegisterPrinter(t *testing.T) {
@@ -308,8 +656,7 @@ func TestLessFunc(t *testing.T) {
 		time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local): 1,
 		time.Date(1990, 1, 1, 0, 0, 0, 0, time.Local): 2,
 	}
-	// Try to exhibit the flakiness.
-	// Without the RegisterLess call above, this loop fails every time.
+	// Try to exhibit any flakiness.
 	for i := 0; i < 10; i++ {
 		got, err := p.Sprint(m)
 		if err != nil {
@@ -325,3 +672,802 @@ func TestLessFunc(t *testing.T) {
 		}
 	}
 }
+
+func TestStructuralKeyOrder(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc")
+	p1, p2 := &Point{2, 1}, &Point{1, 2}
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{
+			map[Point]int{{2, 1}: 1, {1, 2}: 2, {1, 1}: 3},
+			"map[Point]int{{x: 1, y: 1}: 3, {x: 1, y: 2}: 2, {x: 2, y: 1}: 1}",
+		},
+		{
+			map[[2]string]bool{{"b", "a"}: true, {"a", "b"}: false, {"a", "a"}: true},
+			`map[[2]string]bool{{"a","a",}: true,{"a","b",}: false,{"b","a",}: true,}`,
+		},
+		{
+			map[*Point]int{p1: 1, p2: 2, nil: 0},
+			"map[*Point]int{nil: 0,{x: 1, y: 2}: 2,{x: 2, y: 1}: 1,}",
+		},
+		{
+			map[interface{}]int{"b": 1, 2: 2, "a": 3, 1: 4, nil: 5, Point{}: 6, 1.5: 7},
+			`map[interface{}]int{nil: 5,1.5: 7,1: 4,2: 2,Point{}: 6,"a": 3,"b": 1,}`,
+		},
+		{
+			map[complex64]bool{2i: true, 1 + 1i: false, 1: true},
+			"map[complex64]bool{(0+2i): true, (1+0i): true, (1+1i): false}",
+		},
+	} {
+		// Repeat to exhibit any flakiness.
+		for i := 0; i < 10; i++ {
+			got, err := p.Sprint(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+			if got != test.want {
+				t.Errorf("%#v:\ngot\n%s\nwant\n%s", test.in, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRequireSortedKeys(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").RequireSortedKeys()
+	c1, c2 := make(chan int), make(chan int)
+	m := map[chan int]bool{c1: true, c2: false}
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{m, "map at value"},
+		{struct{ M map[chan int]bool }{m}, "map at value.M"},
+		{[]*Nested{nil, {}}, ""},
+		{
+			[]interface{}{map[string]interface{}{"a": m}},
+			`map at value[0].(map[string]interface {})["a"].(map[chan int]bool)`,
+		},
+		{map[chan int]bool{c1: true}, ""},
+		{map[interface{}]int{c1: 1, c2: 2}, "cannot sort keys of type interface {} in map at value"},
+		{map[interface{}]int{c1: 1, "a": 2}, ""},
+		{map[*Point]int{{1, 2}: 1, {1, 2}: 2}, "cannot sort keys of type *printsrc.Point"},
+		{map[*Point]int{{1, 2}: 1, {2, 1}: 2}, ""},
+		{map[struct {
+			P *Point
+			N int
+		}]bool{{&Point{1, 2}, 1}: true, {&Point{1, 2}, 2}: false}, ""},
+	} {
+		_, err := p.Sprint(test.in)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("%T: %v", test.in, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%T: got %v, looking for %q", test.in, err, test.want)
+		}
+	}
+
+	_, err := p.SprintDecls("x", &struct{ M map[chan int]bool }{m})
+	if want := "cannot sort keys of type chan int in map at (*x).M"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, looking for %q", err, want)
+	}
+}
+
+func TestPrintDecls(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc")
+	i := 5
+	n := &node{v: 1}
+	m := map[string]int{"a": 1}
+	s := []int{1, 2}
+	c := make(chan int)
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{1, "var x = 1"},
+		{&node{v: 1}, "var x = &node{v: 1}"},
+		{[]*node{n, n}, "var x = []*node{_x1,_x1,}var _x1 = &node{v: 1}"},
+		{[]*int{&i, &i}, "var x = []*int{_x1,_x1,}var _x1 = func() *int { var x int = 5; return &x }()"},
+		{[]map[string]int{m, m}, `var x = []map[string]int{_x1,_x1,}var _x1 = map[string]int{"a": 1}`},
+		{[][]int{s, s, s[:1]}, "var x = [][]int{_x1,_x1,{1},}var _x1 = []int{1, 2}"},
+		{[]chan int{c, c, make(chan int)}, "var x = []chan int{_x1,_x1,make(chan int),}var _x1 = make(chan int)"},
+		{
+			[]interface{}{&node{v: 2, next: n}, n},
+			"var x = []interface{}{&node{v: 2,next: _x1,},_x1,}var _x1 = &node{v: 1}",
+		},
+	} {
+		got, err := p.SprintDecls("x", test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+		if got != test.want {
+			t.Errorf("%#v (%[1]T):\ngot\n%s\nwant\n%s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPrintDeclsCycles(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc")
+	self := &node{v: 1}
+	self.next = self
+	a := &node{v: 1}
+	b := &node{v: 2, next: a}
+	a.next = b
+	s := []interface{}{1, nil}
+	s[1] = s
+	m := map[string]*node{}
+	m["a"] = &node{v: 3}
+	m["b"] = m["a"]
+	type holder struct{ Any interface{} }
+	h := &holder{}
+	h.Any = &node{v: 4, next: &node{v: 5}}
+	h.Any.(*node).next.next = h.Any.(*node)
+	for i, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{self, "var x = &node{v: 1,next: nil,}func init() {x.next = x}"},
+		{
+			[]*node{a, b},
+			"var x = []*node{_x1,_x2,}" +
+				"var _x1 = &node{v: 1,next: _x2,}" +
+				"var _x2 = &node{v: 2,next: nil,}" +
+				"func init() {_x2.next = _x1}",
+		},
+		{s, "var x = []interface{}{1,nil,}func init() {x[1] = x}"},
+		{
+			h,
+			"var x = &holder{Any: _x1}" +
+				"var _x1 = &node{v: 4,next: &node{v: 5,next: nil,},}" +
+				"func init() {_x1.next.next = _x1}",
+		},
+	} {
+		got, err := p.SprintDecls("x", test.in)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+		if got != test.want {
+			t.Errorf("#%d:\ngot\n%s\nwant\n%s", i, got, test.want)
+		}
+	}
+}
+
+func TestImports(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").Import("text/template", "ttemp")
+	if got := p.Imports(); len(got) != 0 {
+		t.Errorf("before printing: got %v, want empty", got)
+	}
+	for _, v := range []interface{}{
+		Point{1, 2},
+		[]interface{}{net.Flags(1), math.Inf(1)},
+		map[string]template.Template{"t": {}},
+		time.Date(2008, 4, 23, 9, 56, 23, 29, time.UTC),
+	} {
+		if _, err := p.Sprint(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := p.Imports()
+	want := map[string]string{
+		"math":          "math",
+		"net":           "net",
+		"text/template": "ttemp",
+		"time":          "time",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFile(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").Import("text/template", "ttemp")
+	if _, err := p.Sprint(math.Inf(1)); err != nil {
+		t.Fatal(err)
+	}
+	n := &Nested{B: 1}
+	got, err := p.NewFile("TestFile").
+		Var("a", []*Nested{n}).
+		Var("b", map[string]interface{}{"n": n, "t": template.Template{}}).
+		Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `// Code generated by TestFile. DO NOT EDIT.
+
+package printsrc
+
+import ttemp "text/template"
+
+var a = []*Nested{
+	_a1,
+}
+
+var b = map[string]interface{}{
+	"n": _a1,
+	"t": ttemp.Template{},
+}
+
+var _a1 = &Nested{B: 1}
+`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	// The Printer's imports include those from before the file was printed.
+	if got, want := len(p.Imports()), 2; got != want {
+		t.Errorf("got %d imports, want %d", got, want)
+	}
+}
+
+func TestFileSharedNames(t *testing.T) {
+	// The generated names for a's shared values go up to _a12, skipping _a2.
+	// They include _a11 and _a12, the first names generated for a1.
+	var a []*Nested
+	for i := int16(0); i < 11; i++ {
+		n := &Nested{B: i}
+		a = append(a, n, n)
+	}
+	n := &Nested{B: 100}
+	src, err := NewPrinter("github.com/jba/printsrc").NewFile("TestFileSharedNames").
+		Var("a", a).
+		Var("a1", []*Nested{n, n}).
+		Var("_a2", 0).
+		Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"var _a3 = &Nested{B: 1}", "var _a12 = &Nested{B: 10}", "var _a13 = &Nested{B: 100}"} {
+		if n := strings.Count(string(src), want); n != 1 {
+			t.Errorf("%q appears %d times in\n%s", want, n, src)
+		}
+	}
+	if strings.Contains(string(src), "var _a2 = &") {
+		t.Errorf("generated name clashes with variable _a2:\n%s", src)
+	}
+}
+
+func TestPointerHelper(t *testing.T) {
+	p := NewPrinter("github.com/jba/printsrc").PointerHelper("ptr")
+	i, i8, u, str, f32, b := 7, int8(7), uint(7), "s", float32(math.Inf(1)), Bool(true)
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{&i, "ptr(7)"},
+		{&i8, "ptr[int8](7)"},
+		{&u, "ptr[uint](0x7)"},
+		{&str, `ptr("s")`},
+		{&f32, "ptr[float32](float32(math.Inf(1)))"},
+		{&b, "ptr[Bool](true)"},
+		{[]*int{&i, nil}, "[]*int{ptr(7),nil,}"},
+		{&Nested{B: 1}, "&Nested{B: 1}"},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+		if got != test.want {
+			t.Errorf("%T: got %s, want %s", test.in, got, test.want)
+		}
+	}
+
+	src, err := p.NewFile("TestPointerHelper").Var("a", []*int{&i}).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `// Code generated by TestPointerHelper. DO NOT EDIT.
+
+package printsrc
+
+var a = []*int{
+	ptr(7),
+}
+
+// ptr returns a pointer to its argument.
+func ptr[T any](x T) *T { return &x }
+`
+	if got := string(src); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// A file that doesn't use the helper doesn't declare it.
+	src, err = p.NewFile("TestPointerHelper").Var("a", 1).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(src), "func ptr") {
+		t.Errorf("helper declared in\n%s", src)
+	}
+}
+
+func TestPrintPointers(t *testing.T) {
+	i := 5
+	pi := &i
+	tm := time.Date(2008, 4, 23, 9, 56, 23, 29, time.UTC)
+	e := errors.New("x")
+	var ns []int
+	nan := map[float64]int{math.NaN(): 1}
+	var pt interface{} = Point{1, 2}
+	var st fmt.Stringer = time.Second
+	pn := &Nested{B: 1}
+	pns := &[]int{1}
+	for _, test := range []struct {
+		in         interface{}
+		want       string
+		wantHelper string
+	}{
+		{
+			&pi,
+			"func() **int { var x *int = func() *int { var x int = 5; return &x }(); return &x }()",
+			"ptr(ptr(5))",
+		},
+		{
+			&tm,
+			"func() *time.Time { var x time.Time = time.Date(2008, time.April, 23, 9, 56, 23, 29, time.UTC); return &x }()",
+			"ptr(time.Date(2008, time.April, 23, 9, 56, 23, 29, time.UTC))",
+		},
+		{
+			[]*time.Time{&tm},
+			"[]*time.Time{func() *time.Time { var x time.Time = time.Date(2008, time.April, 23, 9, 56, 23, 29, time.UTC); return &x }(),}",
+			"[]*time.Time{ptr(time.Date(2008, time.April, 23, 9, 56, 23, 29, time.UTC)),}",
+		},
+		{
+			&e,
+			`func() *error { var x error = errors.New("x"); return &x }()`,
+			`ptr[error](errors.New("x"))`,
+		},
+		{
+			&ns,
+			"func() *[]int { var x []int = []int(nil); return &x }()",
+			"ptr([]int(nil))",
+		},
+		{
+			[]*[]int{&ns},
+			"[]*[]int{func() *[]int { var x []int = []int(nil); return &x }(),}",
+			"[]*[]int{ptr([]int(nil)),}",
+		},
+		{
+			&nan,
+			"func() *map[float64]int { var x map[float64]int = func() map[float64]int {m := map[float64]int{}m[math.NaN()] = 1return m}(); return &x }()",
+			"ptr(func() map[float64]int {m := map[float64]int{}m[math.NaN()] = 1return m}())",
+		},
+		{
+			&pt,
+			"func() *interface{} { var x interface{} = Point{x: 1, y: 2}; return &x }()",
+			"ptr[interface{}](Point{x: 1, y: 2})",
+		},
+		{
+			[]*interface{}{&pt},
+			"[]*interface{}{func() *interface{} { var x interface{} = Point{x: 1, y: 2}; return &x }(),}",
+			"[]*interface{}{ptr[interface{}](Point{x: 1, y: 2}),}",
+		},
+		{
+			&st,
+			"func() *fmt.Stringer { var x fmt.Stringer = time.Second; return &x }()",
+			"ptr[fmt.Stringer](time.Second)",
+		},
+		{&Nested{B: 1}, "&Nested{B: 1}", "&Nested{B: 1}"},
+		{[]*Nested{{B: 1}}, "[]*Nested{{B: 1},}", "[]*Nested{{B: 1},}"},
+		{
+			[]**Nested{&pn},
+			"[]**Nested{func() **Nested { var x *Nested = &Nested{B: 1}; return &x }(),}",
+			"[]**Nested{ptr(&Nested{B: 1}),}",
+		},
+		{
+			map[string]**Nested{"a": &pn},
+			`map[string]**Nested{"a": func() **Nested { var x *Nested = &Nested{B: 1}; return &x }(),}`,
+			`map[string]**Nested{"a": ptr(&Nested{B: 1}),}`,
+		},
+		{
+			[]**[]int{&pns},
+			"[]**[]int{func() **[]int { var x *[]int = &[]int{1}; return &x }(),}",
+			"[]**[]int{ptr(&[]int{1}),}",
+		},
+		{
+			[]*net.IPNet{{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}},
+			`[]*net.IPNet{&net.IPNet{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(8, 32)},}`,
+			`[]*net.IPNet{&net.IPNet{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(8, 32)},}`,
+		},
+	} {
+		for _, p := range []*Printer{NewPrinter("github.com/jba/printsrc"), NewPrinter("github.com/jba/printsrc").PointerHelper("ptr")} {
+			want := test.want
+			if p.ptrHelper != "" {
+				want = test.wantHelper
+			}
+			got, err := p.Sprint(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+			if got != want {
+				t.Errorf("%T:\ngot\n%s\nwant\n%s", test.in, got, want)
+			}
+		}
+	}
+}
+
+func TestGoVersion(t *testing.T) {
+	i := 5
+	for _, test := range []struct {
+		version string
+		in      interface{}
+		want    string
+	}{
+		{"1.17", []interface{}{}, "[]interface{}{}"},
+		{"1.18", []interface{}{}, "[]any{}"},
+		{"1.18", map[string]interface{ M() }{}, "map[string]interface{ M() }{}"},
+		// Only a File uses a pointer helper by default.
+		{"1.18", &i, "func() *int { var x int = 5; return &x }()"},
+		{"1.21", reflect.TypeOf(0), "reflect.TypeOf((*int)(nil)).Elem()"},
+		{"go1.22", reflect.TypeOf(0), "reflect.TypeFor[int]()"},
+		{"1.22.1", reflect.TypeOf([]error{}), "reflect.TypeFor[[]error]()"},
+	} {
+		p := NewPrinter("github.com/jba/printsrc").GoVersion(test.version)
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s, %T: got %s, want %s", test.version, test.in, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		p          *Printer
+		wantHelper bool
+	}{
+		{NewPrinter("github.com/jba/printsrc"), false},
+		{NewPrinter("github.com/jba/printsrc").GoVersion("1.17"), false},
+		{NewPrinter("github.com/jba/printsrc").GoVersion("1.18"), true},
+		{NewPrinter("github.com/jba/printsrc").GoVersion("1.21").GoVersion("1.17"), false},
+	} {
+		src, err := test.p.NewFile("TestGoVersion").Var("x", &i).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotHelper := strings.Contains(string(src), "var x = ptr(5)") && strings.Contains(string(src), "func ptr[T any]")
+		if gotHelper != test.wantHelper {
+			t.Errorf("go1.%d: got helper %t, want %t:\n%s", test.p.goMinor, gotHelper, test.wantHelper, src)
+		}
+		// The File's helper is not used outside of it.
+		if got, _ := test.p.Sprint(&i); strings.HasPrefix(got, "ptr") {
+			t.Errorf("go1.%d: Sprint after File: got %s", test.p.goMinor, got)
+		}
+	}
+
+	_, err := NewPrinter("github.com/jba/printsrc").GoVersion("1.18").NewFile("TestGoVersion").Var("ptr", &i).Source()
+	if want := "variable ptr has the same name as the pointer helper"; err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+func TestParseGoVersion(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want int
+	}{
+		{"1.16", 16},
+		{"go1.21", 21},
+		{"1.21.3", 21},
+		{"1.22rc1", 22},
+		{"2.0", -1},
+		{"1.", -1},
+		{"", -1},
+	} {
+		got, err := parseGoVersion(test.in)
+		if err != nil {
+			got = -1
+		}
+		if got != test.want {
+			t.Errorf("%q: got %d, want %d", test.in, got, test.want)
+		}
+	}
+}
+
+func TestModuleGoVersion(t *testing.T) {
+	for _, test := range []struct {
+		gomod string
+		want  string
+	}{
+		{"module m\n\ngo 1.21\n", "1.21"},
+		{"module m\n\ngo 1.22.3 // comment\n\nrequire x v1.0.0\n", "1.22.3"},
+		// A go.mod file without a go directive is for Go 1.16.
+		{"module m\n", "1.16"},
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(test.gomod), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// The go.mod file is found from a subdirectory.
+		sub := filepath.Join(dir, "a", "b")
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ModuleGoVersion(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.gomod, got, test.want)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module m\n\ngo 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ModuleGoVersion(dir); err == nil {
+		t.Error("go 2: got nil, want error")
+	}
+}
+
+func TestFileErrors(t *testing.T) {
+	for _, test := range []struct {
+		names  []string
+		values []interface{}
+		want   string
+	}{
+		{
+			[]string{"a", "b"},
+			[]interface{}{(*template.Template)(nil), (*htmltemplate.Template)(nil)},
+			`packages "html/template" and "text/template" are both referred to as template`,
+		},
+		{
+			[]string{"time"},
+			[]interface{}{time.Second},
+			`variable time has the same name as the identifier of imported package "time"`,
+		},
+		{[]string{"a", "b", "a"}, []interface{}{1, 2, 3}, "variable a is declared more than once"},
+		{[]string{"a-b"}, []interface{}{1}, `variable name "a-b" is not an identifier`},
+		{[]string{"var"}, []interface{}{1}, `variable name "var" is not an identifier`},
+	} {
+		f := NewPrinter("github.com/jba/printsrc").NewFile("TestFileErrors")
+		for i, name := range test.names {
+			f.Var(name, test.values[i])
+		}
+		_, err := f.Source()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got %v, looking for %q", test.names, err, test.want)
+		}
+	}
+}
+
+func TestResolvePackageNames(t *testing.T) {
+	for _, test := range []struct {
+		pkgPath         string
+		guess, resolved string
+	}{
+		{"text/template", "template", "template"},
+		{"go/build/constraint", "constraint", "constraint"},
+		{"example.com/no/such/package.v3", "package.v3", "package.v3"},
+	} {
+		if got := NewPrinter("x").PackageIdentifier(test.pkgPath); got != test.guess {
+			t.Errorf("%s: got %q, want %q", test.pkgPath, got, test.guess)
+		}
+		p := NewPrinter("x").ResolvePackageNames("")
+		if got := p.PackageIdentifier(test.pkgPath); got != test.resolved {
+			t.Errorf("%s, resolved: got %q, want %q", test.pkgPath, got, test.resolved)
+		}
+	}
+}
+
+func TestResolvePackageNamesRelativeDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"go.mod":        "module m\n\ngo 1.18\n",
+		"sub/v2/sub.go": "package sub\n",
+	} {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{dir, rel} {
+		if got, want := NewPrinter("x").ResolvePackageNames(d).PackageIdentifier("m/sub/v2"), "sub"; got != want {
+			t.Errorf("%s: got %q, want %q", d, got, want)
+		}
+	}
+}
+
+// textInt marshals itself with a value receiver.
+type textInt struct{ n int }
+
+func (t textInt) MarshalText() ([]byte, error) { return []byte(strconv.Itoa(t.n)), nil }
+
+func (t *textInt) UnmarshalText(b []byte) (err error) {
+	t.n, err = strconv.Atoi(string(b))
+	return err
+}
+
+// binBool marshals itself only to binary.
+type binBool bool
+
+func (b binBool) MarshalBinary() ([]byte, error) {
+	if b {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+func (b *binBool) UnmarshalBinary(data []byte) error {
+	*b = len(data) == 1 && data[0] == 1
+	return nil
+}
+
+// binStruct marshals itself only to binary.
+type binStruct struct{ b binBool }
+
+func (b binStruct) MarshalBinary() ([]byte, error) { return b.b.MarshalBinary() }
+
+func (b *binStruct) UnmarshalBinary(data []byte) error { return b.b.UnmarshalBinary(data) }
+
+// textLevel is an integer that marshals itself to text.
+type textLevel int
+
+func (l textLevel) MarshalText() ([]byte, error) { return []byte(strconv.Itoa(int(l))), nil }
+
+func (l *textLevel) UnmarshalText(b []byte) (err error) {
+	n, err := strconv.Atoi(string(b))
+	*l = textLevel(n)
+	return err
+}
+
+func TestUseMarshalers(t *testing.T) {
+	// Print from another package, so textInt's field is not printable.
+	p := NewPrinter("github.com/jba/printsrc/x").UseMarshalers()
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{
+			&textInt{-7},
+			`func() *printsrc.textInt { x := new(printsrc.textInt); if err := x.UnmarshalText([]byte("-7")); err != nil { panic(err) }; return x }()`,
+		},
+		{
+			[]interface{}{textInt{3}},
+			`[]interface{}{func() printsrc.textInt { var x printsrc.textInt; if err := x.UnmarshalText([]byte("3")); err != nil { panic(err) }; return x }(),}`,
+		},
+		{
+			binStruct{true},
+			`func() printsrc.binStruct { var x printsrc.binStruct; if err := x.UnmarshalBinary([]byte("\x01")); err != nil { panic(err) }; return x }()`,
+		},
+		{(*textInt)(nil), "(*printsrc.textInt)(nil)"},
+		{textInt{}, "printsrc.textInt{}"},
+		// Values that can be printed otherwise are.
+		{binBool(true), "printsrc.binBool(true)"},
+		{
+			struct {
+				Name string
+				L    textLevel
+			}{"a", 3},
+			`struct{ Name string; L printsrc.textLevel }{Name: "a",L: 3,}`,
+		},
+		// Custom print functions take precedence.
+		{
+			time.Date(2008, 4, 23, 9, 56, 23, 29, time.UTC),
+			"time.Date(2008, time.April, 23, 9, 56, 23, 29, time.UTC)",
+		},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = strings.NewReplacer("\n", "", "\t", "").Replace(got)
+		if got != test.want {
+			t.Errorf("%#v (%[1]T):\ngot\n\t%s\nwant\n\t%s", test.in, got, test.want)
+		}
+	}
+
+	// A print function for a value is used for pointers to it too.
+	p.PrintFuncs(func(x textInt) string { return fmt.Sprintf("mk(%d)", x.n) })
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{textInt{1}, "mk(1)"},
+		{&textInt{2}, "func() *printsrc.textInt { var x printsrc.textInt = mk(2); return &x }()"},
+		{struct{ P *textInt }{&textInt{3}}, "struct{ P *printsrc.textInt }{P: func() *printsrc.textInt { var x printsrc.textInt = mk(3); return &x }()}"},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%#v (%[1]T):\ngot\n\t%s\nwant\n\t%s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPrintLocations(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPrinter("github.com/jba/printsrc")
+	for _, test := range []struct {
+		in   interface{}
+		want string
+	}{
+		{time.UTC, "time.UTC"},
+		{time.Local, "time.Local"},
+		{time.FixedZone("foo", 17), `time.FixedZone("foo", 17)`},
+		{time.FixedZone("", -3600), `time.FixedZone("", -3600)`},
+		{
+			ny,
+			`func() *time.Location { loc, err := time.LoadLocation("America/New_York"); if err != nil { panic(err) }; return loc }()`,
+		},
+		{
+			time.Date(2008, 4, 23, 9, 56, 23, 29, time.FixedZone("foo", 17)),
+			`time.Date(2008, time.April, 23, 9, 56, 23, 29, time.FixedZone("foo", 17))`,
+		},
+		{
+			time.Date(2008, 4, 23, 9, 56, 23, 29, ny),
+			`time.Date(2008, time.April, 23, 9, 56, 23, 29, func() *time.Location { loc, err := time.LoadLocation("America/New_York"); if err != nil { panic(err) }; return loc }())`,
+		},
+	} {
+		got, err := p.Sprint(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%v:\ngot\n\t%s\nwant\n\t%s", test.in, got, test.want)
+		}
+	}
+	// The form of each location, other than UTC and Local, is found once.
+	// Each call to FixedZone creates a different location.
+	if got, want := len(p.locForms), 4; got != want {
+		t.Errorf("got %d location forms, want %d", got, want)
+	}
+
+	// A location that can be neither loaded nor created with FixedZone.
+	data, err := os.ReadFile("/usr/share/zoneinfo/America/New_York")
+	if err != nil {
+		t.Logf("skipping unknown location: %v", err)
+	} else {
+		loc, err := time.LoadLocationFromTZData("Nowhere/Special", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.Sprint(time.Date(2008, 4, 23, 9, 56, 23, 29, loc))
+		if err == nil || !strings.Contains(err.Error(), "location") {
+			t.Errorf("got %v, want error about location", err)
+		}
+	}
+
+	// Normalizing local times.
+	p.LocalTimesIn(time.UTC)
+	local := time.Date(2008, 4, 23, 9, 56, 23, 29, time.Local)
+	got, err := p.Sprint(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := local.UTC()
+	want := fmt.Sprintf("time.Date(%d, time.%s, %d, %d, 56, 23, 29, time.UTC)", u.Year(), u.Month(), u.Day(), u.Hour())
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	got, err = p.Sprint(time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "time.UTC"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 by Jonathan Amsterdam. All rights reserved.
+
+//go:build go1.16
+// +build go1.16
+
+package printsrc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultGoMinor is the minor version of Go that printed code is written for
+// if GoVersion isn't called.
+const defaultGoMinor = 16
+
+// GoVersion sets the oldest version of Go that will build the printed code,
+// like "1.21" or "go1.21". Usually it is the version in the go directive of the
+// go.mod file of the module the code will be part of; see ModuleGoVersion.
+// Printed code uses the newest syntax and library functions that the version
+// supports. By default, it is written for Go 1.16.
+//
+// Starting with Go 1.18, the empty interface is written as any, and a File
+// uses PointerHelper("ptr") unless another helper is set. Starting with Go
+// 1.22, a reflect.Type is printed with reflect.TypeFor. Before Go 1.20, an
+// error that wraps more than one error can't be printed.
+//
+// GoVersion panics if version is not a Go 1 version.
+//
+// It returns its receiver to support chaining.
+func (p *Printer) GoVersion(version string) *Printer {
+	minor, err := parseGoVersion(version)
+	if err != nil {
+		panic(err)
+	}
+	p.goMinor = minor
+	return p
+}
+
+// goAtLeast reports whether the printed code can use features of Go 1.minor.
+func (p *Printer) goAtLeast(minor int) bool {
+	if p.goMinor == 0 {
+		return defaultGoMinor >= minor
+	}
+	return p.goMinor >= minor
+}
+
+// parseGoVersion returns the minor version of a Go 1 version like "1.21",
+// "1.21.3", "1.21rc1" or "go1.21".
+func parseGoVersion(version string) (int, error) {
+	v := strings.TrimPrefix(version, "go")
+	if !strings.HasPrefix(v, "1.") {
+		return 0, fmt.Errorf("printsrc: bad Go version %q", version)
+	}
+	v = v[2:]
+	i := 0
+	for i < len(v) && '0' <= v[i] && v[i] <= '9' {
+		i++
+	}
+	minor, err := strconv.Atoi(v[:i])
+	if err != nil {
+		return 0, fmt.Errorf("printsrc: bad Go version %q", version)
+	}
+	return minor, nil
+}
+
+// ModuleGoVersion returns the version in the go directive of the go.mod file
+// of the module containing dir. It looks for the file in dir and its parents.
+func ModuleGoVersion(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		f, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			defer f.Close()
+			return goDirective(f.Name(), bufio.NewScanner(f))
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod file in %s or its parents", dir)
+		}
+		dir = parent
+	}
+}
+
+// goDirective returns the version in the go directive of the go.mod file read
+// by s. A go.mod file without one is for Go 1.16.
+func goDirective(filename string, s *bufio.Scanner) (string, error) {
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "go" {
+			if _, err := parseGoVersion(fields[1]); err != nil {
+				return "", fmt.Errorf("%s: %v", filename, err)
+			}
+			return fields[1], nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "1.16", nil
+}